@@ -24,22 +24,252 @@ if [[ -z "$SONAR_TOKEN" ]]; then
   echo "Warning: SONAR_TOKEN environment variable not set."
 fi
 
-WORKDIR="/data/repo"
+# Where the backend's directories are: mounted at these paths in the
+# analysis container, or host paths given in the environment when the
+# backend runs this script directly (SCAN_RUNNER=local). An empty
+# DETEKT_CONFIG_DIR or ANALYZERS_DIR means there is none.
+DATA_DIR="${DATA_DIR:-/data}"
+DETEKT_CONFIG_DIR="${DETEKT_CONFIG_DIR-/detekt-config}"
+SECRETS_DIR="${SECRETS_DIR:-/secrets}"
+ANALYZERS_DIR="${ANALYZERS_DIR-/opt/analyzers.d}"
+SCRATCH_DIR="${TMPDIR:-/tmp}"
+
+WORKDIR="$DATA_DIR/repo"
 
 # Clean up any previous data
 rm -rf "$WORKDIR"
 mkdir -p "$WORKDIR"
 
-echo "Cloning repository: $REPO_URL"
-git clone "$REPO_URL" "$WORKDIR"
+clone_repository() {
+  # Credentials for private repositories are mounted as files in
+  # $SECRETS_DIR for the clone only; GIT_CREDENTIALS_TYPE says which kind is
+  # there.
+  export GIT_TERMINAL_PROMPT=0
+  case "$GIT_CREDENTIALS_TYPE" in
+    https_token)
+      cat >"$SCRATCH_DIR/git-askpass.sh" <<ASKPASS
+#!/bin/sh
+case "\$1" in
+  Username*) cat "$SECRETS_DIR/username" ;;
+  *) cat "$SECRETS_DIR/token" ;;
+esac
+ASKPASS
+      chmod 700 "$SCRATCH_DIR/git-askpass.sh"
+      export GIT_ASKPASS="$SCRATCH_DIR/git-askpass.sh"
+      ;;
+    ssh_key)
+      export GIT_SSH_COMMAND="ssh -i $SECRETS_DIR/ssh_key -o IdentitiesOnly=yes -o StrictHostKeyChecking=accept-new -o UserKnownHostsFile=$SCRATCH_DIR/known_hosts"
+      ;;
+  esac
+
+  # The backend checked the addresses the repository's host resolves to and
+  # passes the one to connect to, for http(s) as a curl resolve entry and for
+  # ssh as options. Resolving the host again could give another address.
+  PIN_CONFIG=()
+  if [[ -n "$REPO_CURL_RESOLVE" ]]; then
+    PIN_CONFIG=(-c "http.curloptResolve=$REPO_CURL_RESOLVE")
+  fi
+  if [[ -n "$REPO_SSH_OPTIONS" ]]; then
+    export GIT_SSH_COMMAND="${GIT_SSH_COMMAND:-ssh} $REPO_SSH_OPTIONS"
+  fi
+
+  echo "Cloning repository: $REPO_URL"
+  if [[ -n "$LOCAL_REPO" ]]; then
+    # A repository on the server, mounted read-only. It is usually owned by
+    # another user, which git refuses unless it is marked safe.
+    git config --global --add safe.directory "$LOCAL_REPO"
+    git config --global --add safe.directory "$LOCAL_REPO/.git"
+    git clone --no-local "$LOCAL_REPO" "$WORKDIR"
+  else
+    # Redirects are not followed: the backend checked where REPO_URL points,
+    # not where a redirect would lead.
+    git -c http.followRedirects=false "${PIN_CONFIG[@]}" clone -- "$REPO_URL" "$WORKDIR"
+  fi
+
+  # The credentials are not needed past this point.
+  if [[ -n "$GIT_CREDENTIALS_TYPE" ]]; then
+    rm -f "$SECRETS_DIR"/* "$SCRATCH_DIR/git-askpass.sh"
+    unset GIT_ASKPASS GIT_CREDENTIALS_TYPE
+  fi
+  unset GIT_SSH_COMMAND
+
+  # REPO_REF optionally selects a branch, tag or commit SHA instead of the
+  # default branch. Remote branches are tried first so that a branch name wins
+  # over a tag of the same name.
+  if [[ -n "$REPO_REF" ]]; then
+    echo "Checking out $REPO_REF"
+    if git -C "$WORKDIR" rev-parse --verify --quiet "refs/remotes/origin/$REPO_REF^{commit}" >/dev/null; then
+      git -C "$WORKDIR" checkout --quiet --detach "refs/remotes/origin/$REPO_REF"
+    elif git -C "$WORKDIR" rev-parse --verify --quiet "$REPO_REF^{commit}" >/dev/null; then
+      git -C "$WORKDIR" checkout --quiet --detach "$REPO_REF^{commit}"
+    else
+      echo "Error: ref $REPO_REF not found in $REPO_URL"
+      exit 1
+    fi
+  fi
+
+  # REPO_COMMIT pins the commit to analyze, e.g. the one a push reported,
+  # even if the branch has moved on since.
+  if [[ -n "$REPO_COMMIT" ]]; then
+    if ! git -C "$WORKDIR" checkout --quiet --detach "$REPO_COMMIT^{commit}"; then
+      echo "Error: commit $REPO_COMMIT not found in $REPO_URL"
+      exit 1
+    fi
+  fi
+}
+
+record_commit() {
+  # Record what is being analyzed for the backend: commit SHA, branch, author,
+  # commit date and message, one per line.
+  BRANCH=""
+  if [[ -z "$REPO_REF" ]]; then
+    BRANCH=$(git -C "$WORKDIR" rev-parse --abbrev-ref HEAD)
+  elif git -C "$WORKDIR" rev-parse --verify --quiet "refs/remotes/origin/$REPO_REF" >/dev/null; then
+    BRANCH="$REPO_REF"
+  fi
+  {
+    git -C "$WORKDIR" rev-parse HEAD
+    echo "$BRANCH"
+    git -C "$WORKDIR" log -1 --format='%an <%ae>%n%cI%n%B'
+  } >"$DATA_DIR/git-info.txt"
+  echo "Analyzing commit $(git -C "$WORKDIR" rev-parse --short HEAD)${BRANCH:+ on $BRANCH}"
+}
+
+# Lines starting with "::phase::" are picked up by the backend to report
+# scan progress.
+echo "::phase::cloning"
+
+# SOURCE_DIR holds uploaded sources, analyzed instead of a clone. They may
+# not be a git checkout, in which case no commit is recorded.
+if [[ -n "$SOURCE_DIR" ]]; then
+  echo "Copying uploaded sources"
+  cp -a "$SOURCE_DIR"/. "$WORKDIR"/
+  if git -C "$WORKDIR" rev-parse --verify --quiet HEAD >/dev/null 2>&1; then
+    record_commit
+  fi
+else
+  clone_repository
+  record_commit
+fi
+
+# Record the versions of the tools, one "tool=version" per line, for the
+# backend to tell results apart that changed with the toolchain rather than
+# the code. A tool whose version can't be told is left out.
+tool_version() {
+  "$@" 2>/dev/null | grep -oE '[0-9]+(\.[0-9]+)+' | head -n1
+}
+{
+  echo "detekt=$(tool_version detekt --version)"
+  echo "ktlint=$(tool_version ktlint --version)"
+  echo "sonar-scanner=$(sonar-scanner --version 2>/dev/null | grep -oE 'SonarScanner( CLI)? [0-9][0-9.]*' | grep -oE '[0-9][0-9.]*' | head -n1)"
+} >"$DATA_DIR/tool-versions.txt"
 
+# SOURCE_PATHS optionally restricts the analysis to directories of the
+# repository, such as modules of a monorepo; EXCLUDE_GLOBS leaves files out.
+# Both are comma-separated and relative to the repository root.
+IFS=',' read -r -a SOURCE_PATH_LIST <<<"$SOURCE_PATHS"
+IFS=',' read -r -a EXCLUDE_GLOB_LIST <<<"$EXCLUDE_GLOBS"
+DETEKT_INPUT="$WORKDIR"
+KTLINT_PATTERNS=('**/*.kt' '**/*.kts')
+SONAR_SOURCES="."
+if ((${#SOURCE_PATH_LIST[@]} > 0)); then
+  DETEKT_INPUT=""
+  KTLINT_PATTERNS=()
+  for source_path in "${SOURCE_PATH_LIST[@]}"; do
+    if [[ ! -d "$WORKDIR/$source_path" ]]; then
+      echo "Error: source path $source_path not found in the repository"
+      exit 1
+    fi
+    DETEKT_INPUT="${DETEKT_INPUT:+$DETEKT_INPUT,}$WORKDIR/$source_path"
+    KTLINT_PATTERNS+=("$source_path/**/*.kt" "$source_path/**/*.kts")
+  done
+  SONAR_SOURCES="$SOURCE_PATHS"
+  echo "Analyzing source paths: $SOURCE_PATHS"
+fi
+DETEKT_EXCLUDES='**/build/**,**/generated/**,**/out/**'
+KTLINT_EXCLUDES=('!**/build/**' '!**/generated/**')
+for glob in "${EXCLUDE_GLOB_LIST[@]}"; do
+  # detekt matches its excludes against absolute paths.
+  DETEKT_EXCLUDES="$DETEKT_EXCLUDES,**/${glob#\*\*/}"
+  KTLINT_EXCLUDES+=("!$glob")
+done
+
+# DETEKT_CONFIG_MODE selects the detekt config: "custom" for one stored in
+# the backend and mounted in $DETEKT_CONFIG_DIR, "repository" for the
+# repository's own file (DETEKT_CONFIG_PATH, or the usual locations), or
+# "default" for detekt's default rule set. The source and path of the config
+# used go to $DATA_DIR/detekt-config.txt and the config to
+# $DATA_DIR/detekt-config.yml, so the backend can record them on the scan.
+DETEKT_ARGS=()
+DETEKT_CONFIG=""
+DETEKT_CONFIG_SOURCE="default"
+DETEKT_CONFIG_REL=""
+case "$DETEKT_CONFIG_MODE" in
+  custom)
+    DETEKT_CONFIG="$DETEKT_CONFIG_DIR/config.yml"
+    DETEKT_CONFIG_SOURCE="custom"
+    ;;
+  repository)
+    if [[ -n "$DETEKT_CONFIG_PATH" ]]; then
+      candidates=("$DETEKT_CONFIG_PATH")
+    else
+      candidates=(detekt.yml detekt.yaml config/detekt/detekt.yml config/detekt.yml .detekt.yml)
+    fi
+    for candidate in "${candidates[@]}"; do
+      if [[ -f "$WORKDIR/$candidate" ]]; then
+        DETEKT_CONFIG="$WORKDIR/$candidate"
+        DETEKT_CONFIG_SOURCE="repository"
+        DETEKT_CONFIG_REL="$candidate"
+        break
+      fi
+    done
+    [[ -n "$DETEKT_CONFIG" ]] || echo "No detekt config found in the repository; using the default rule set."
+    ;;
+esac
+if [[ -n "$DETEKT_CONFIG" ]]; then
+  echo "Using $DETEKT_CONFIG_SOURCE detekt config${DETEKT_CONFIG_REL:+ $DETEKT_CONFIG_REL}"
+  DETEKT_ARGS+=(--config "$DETEKT_CONFIG" --build-upon-default-config)
+  cp "$DETEKT_CONFIG" "$DATA_DIR/detekt-config.yml"
+fi
+if [[ -n "$DETEKT_CONFIG_DIR" && -f "$DETEKT_CONFIG_DIR/baseline.xml" ]]; then
+  echo "Using the project's detekt baseline"
+  DETEKT_ARGS+=(--baseline "$DETEKT_CONFIG_DIR/baseline.xml")
+fi
+printf '%s\n%s\n' "$DETEKT_CONFIG_SOURCE" "$DETEKT_CONFIG_REL" >"$DATA_DIR/detekt-config.txt"
+
+echo "::phase::detekt"
 echo "Running detekt static analysis..."
-detekt --input "$WORKDIR" \
-  --report xml:/data/detekt-report.xml \
+# Paths in the report are relative to the repository, like ktlint's.
+detekt --input "$DETEKT_INPUT" \
+  --base-path "$WORKDIR" \
+  --report "xml:$DATA_DIR/detekt-report.xml" \
   --parallel \
-  --excludes '**/build/**,**/generated/**,**/out/**' ||
+  --excludes "$DETEKT_EXCLUDES" \
+  "${DETEKT_ARGS[@]}" ||
   true
 
+echo "::phase::ktlint"
+echo "Running ktlint..."
+# ktlint exits non-zero when it finds violations; the report is what matters.
+(cd "$WORKDIR" && ktlint --relative \
+  "--reporter=checkstyle,output=$DATA_DIR/ktlint-report.xml" \
+  "${KTLINT_PATTERNS[@]}" "${KTLINT_EXCLUDES[@]}" >/dev/null) ||
+  true
+
+# Extra tools: images built on this one can add executables to
+# $ANALYZERS_DIR (/opt/analyzers.d). Each runs in the repository and writes SARIF 2.1.0 logs
+# to $SARIF_OUTPUT_DIR, which the backend ingests per tool.
+export SARIF_OUTPUT_DIR="$DATA_DIR/sarif"
+mkdir -p "$SARIF_OUTPUT_DIR"
+if [[ -n "$ANALYZERS_DIR" && -d "$ANALYZERS_DIR" ]]; then
+  for analyzer in "$ANALYZERS_DIR"/*; do
+    [[ -x "$analyzer" ]] || continue
+    echo "Running extra analyzer $(basename "$analyzer")..."
+    (cd "$WORKDIR" && "$analyzer") || echo "Warning: $(basename "$analyzer") failed."
+  done
+fi
+
+echo "::phase::sonar-upload"
 echo "Running SonarScanner analysis..."
 cd "$WORKDIR"
 
@@ -48,13 +278,16 @@ SCANNER_CMD="sonar-scanner \
   -Dsonar.projectBaseDir=$WORKDIR \
   -Dsonar.projectKey=$SONAR_PROJECT_KEY \
   -Dsonar.projectVersion=$SONAR_ANALYSIS_VERSION \
-  -Dsonar.sources=. \
+  -Dsonar.sources=$SONAR_SOURCES \
   -Dsonar.host.url=$SONAR_HOST_URL"
 
-if [[ -n "$SONAR_TOKEN" ]]; then
-  SCANNER_CMD="$SCANNER_CMD -Dsonar.token=$SONAR_TOKEN"
+if [[ -n "$EXCLUDE_GLOBS" ]]; then
+  SCANNER_CMD="$SCANNER_CMD -Dsonar.exclusions='$EXCLUDE_GLOBS'"
 fi
 
+# The scanner reads SONAR_TOKEN from the environment; it is kept off the
+# command line, which is echoed into the scan log below.
+
 # Optional: uncomment to debug
 # SCANNER_CMD="$SCANNER_CMD -X"
 
@@ -62,4 +295,4 @@ echo "Executing SonarScanner with command:"
 echo "$SCANNER_CMD"
 eval $SCANNER_CMD || true # Do not exit on error for scanner
 
-echo "Analysis finished. Reports should be in /data/"
+echo "Analysis finished. Reports should be in $DATA_DIR/"
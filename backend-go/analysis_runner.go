@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/docker/go-units"
+)
+
+// analysisInputs is what the analysis of a scan works on: the host
+// directories it is given and the part of the repository to analyze. The
+// runner decides how the directories are made available to analyze.sh.
+type analysisInputs struct {
+	// OutputDir is where the tools leave their reports (/data in the
+	// container).
+	OutputDir string
+	// SourceDir, if not empty, holds uploaded sources that are analyzed
+	// instead of cloning the repository. It is mounted read-only.
+	SourceDir string
+	// LocalRepoDir, if not empty, is a repository on the server's filesystem
+	// that is cloned instead of the URL. It is mounted read-only.
+	LocalRepoDir string
+	// Repo is the checked remote repository, for clones of one.
+	Repo checkedRepo
+	// Secrets, if not nil, holds the credentials for the clone. They are
+	// removed once the clone is done and masked in the analysis output.
+	Secrets *cloneSecrets
+	// Sources restricts the analysis to source paths of the repository.
+	Sources projectSources
+	// Detekt configures detekt. DetektConfigDir, if not empty, holds its
+	// stored config and baseline and is mounted read-only.
+	Detekt          projectDetektConfig
+	DetektConfigDir string
+}
+
+// analysisRun is one run of analyze.sh, as handed to an analysisRunner.
+type analysisRun struct {
+	ScanID string
+	// Env is the environment of analyze.sh, apart from the locations of the
+	// directories in Inputs, which are up to the runner.
+	Env    []string
+	Inputs analysisInputs
+	Limits scanLimits
+	// Stdout and Stderr receive the output of analyze.sh.
+	Stdout, Stderr io.Writer
+	// Info adds a note to the scan's log.
+	Info func(msg string)
+}
+
+// analysisRunner runs analyze.sh for a scan, selected by SCAN_RUNNER.
+type analysisRunner interface {
+	// run runs the analysis until it finishes or ctx is done, in which case
+	// it is stopped, and returns what it ran in once that is known. A
+	// non-zero exit status is an error. A runner that can tell the analysis
+	// was killed for exceeding its memory returns a *resourceLimitError.
+	run(ctx context.Context, r analysisRun) (analyzerImage, error)
+	// resolveImage tells what the next analysis would run in, without
+	// running it.
+	resolveImage(ctx context.Context) (analyzerImage, error)
+	// sonarHostURL is where SonarQube is reached from the analysis when
+	// SONAR_SCANNER_HOST_URL is unset.
+	sonarHostURL() string
+	// repoDir is where analyze.sh checks out the repository of an
+	// analysis writing to outputDir, as the tools see it.
+	repoDir(outputDir string) string
+}
+
+// Runners selectable with SCAN_RUNNER.
+const (
+	scanRunnerDocker = "docker" // the repo-analyzer image, the default
+	scanRunnerLocal  = "local"  // analyze.sh run directly on the backend's host
+)
+
+// scanRunner runs the analysis of every scan of this backend instance.
+var scanRunner analysisRunner
+
+func newAnalysisRunner(name string) (analysisRunner, error) {
+	switch name {
+	case "", scanRunnerDocker:
+		return dockerRunner{}, nil
+	case scanRunnerLocal:
+		return newLocalRunner()
+	default:
+		return nil, fmt.Errorf("unknown SCAN_RUNNER %q, expected %q or %q", name, scanRunnerDocker, scanRunnerLocal)
+	}
+}
+
+// runAnalysis runs analyze.sh for a scan with the directories of in and
+// returns the image it ran in. Cancelling ctx stops the analysis; ctx.Err()
+// is returned in that case. An analysis stopped for exceeding one of its
+// limits yields a *resourceLimitError.
+func runAnalysis(ctx context.Context, job scanJob, limits scanLimits, in analysisInputs, setPhase func(phase string), logs *scanLogHub) (analyzerImage, error) {
+	repoURL, sonarProjectKey, scanID := job.RepoURL, job.SonarProjectKey, job.ScanID
+	secrets := in.Secrets
+
+	sonarScannerHostURL := os.Getenv("SONAR_SCANNER_HOST_URL")
+	if sonarScannerHostURL == "" {
+		sonarScannerHostURL = scanRunner.sonarHostURL()
+	}
+
+	sonarToken := os.Getenv("SONAR_LOGIN_TOKEN")
+
+	log.Printf("Starting analysis for project key: %s, version: %s", sonarProjectKey, scanID)
+
+	env := []string{
+		fmt.Sprintf("REPO_URL=%s", repoURL),
+		fmt.Sprintf("REPO_REF=%s", job.Ref),
+		fmt.Sprintf("REPO_COMMIT=%s", job.Commit),
+		fmt.Sprintf("GIT_ALLOW_PROTOCOL=%s", gitAllowProtocol(repoURL)),
+		fmt.Sprintf("SONAR_PROJECT_KEY=%s", sonarProjectKey),
+		fmt.Sprintf("SONAR_ANALYSIS_VERSION=%s", scanID),
+		fmt.Sprintf("SONAR_HOST_URL=%s", sonarScannerHostURL),
+		fmt.Sprintf("SONAR_TOKEN=%s", sonarToken),
+	}
+	env = append(env, in.Repo.containerEnv()...)
+	env = append(env, in.Sources.containerEnv()...)
+	env = append(env, in.Detekt.containerEnv()...)
+	if secrets != nil {
+		// Only the kind of credential goes into the environment, which is
+		// visible through the Docker API; the secret itself is a file.
+		env = append(env, fmt.Sprintf("GIT_CREDENTIALS_TYPE=%s", secrets.Kind))
+	}
+
+	// Mirror the analysis output to our own stdout/stderr and to the scan's
+	// log subscribers, while watching for the phase markers emitted by
+	// analyze.sh. The clone credentials are removed as soon as a phase after
+	// cloning starts.
+	onLine := func(mirror io.Writer, stream string) func(line string) {
+		return func(line string) {
+			line = redactToken(secrets.redactLine(line), sonarToken)
+			fmt.Fprintln(mirror, line)
+			if phase, ok := phaseFromLogLine(line); ok {
+				if phase != scanPhaseCloning {
+					secrets.remove()
+				}
+				setPhase(phase)
+				return
+			}
+			logs.line(stream, line)
+		}
+	}
+
+	// runCtx ends when the scan is cancelled or the analysis hits its
+	// runtime or disk limit; the cause tells which.
+	runCtx, stopRun := context.WithCancelCause(ctx)
+	defer stopRun(nil)
+	if limits.MaxRuntime > 0 {
+		var cancelTimeout context.CancelFunc
+		runCtx, cancelTimeout = context.WithTimeoutCause(runCtx, limits.MaxRuntime,
+			&resourceLimitError{Limit: fmt.Sprintf("maximum runtime of %s", limits.MaxRuntime)})
+		defer cancelTimeout()
+	}
+	go watchDiskUsage(runCtx, in.OutputDir, limits.MaxDiskBytes, func() {
+		stopRun(&resourceLimitError{Limit: fmt.Sprintf("disk limit of %s for /data", units.BytesSize(float64(limits.MaxDiskBytes)))})
+	})
+
+	image, err := scanRunner.run(runCtx, analysisRun{
+		ScanID: scanID,
+		Env:    env,
+		Inputs: in,
+		Limits: limits,
+		Stdout: &logLineWriter{onLine: onLine(os.Stdout, "stdout")},
+		Stderr: &logLineWriter{onLine: onLine(os.Stderr, "stderr")},
+		Info:   logs.info,
+	})
+	if runCtx.Err() != nil {
+		log.Printf("Stopped analysis of scan %s: %v", scanID, context.Cause(runCtx))
+		if ctx.Err() != nil {
+			return image, ctx.Err()
+		}
+		return image, context.Cause(runCtx)
+	}
+	return image, err
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// androidLintAnalyzer ingests Android Lint XML reports. Android Lint needs the
+// Android SDK and a Gradle build of the app, which the analysis image does not
+// have, so its reports are usually produced in CI and uploaded to a scan. An
+// image that does run it can leave the report at /data/android-lint-report.xml.
+type androidLintAnalyzer struct{}
+
+func init() {
+	registerAnalyzer(androidLintAnalyzer{})
+}
+
+// AndroidLintReport is the XML report written by `gradlew lint`.
+type AndroidLintReport struct {
+	XMLName xml.Name `xml:"issues"`
+	By      string   `xml:"by,attr"` // e.g. "lint 8.1.0"
+	Issues  []struct {
+		ID        string `xml:"id,attr"`
+		Severity  string `xml:"severity,attr"`
+		Message   string `xml:"message,attr"`
+		Category  string `xml:"category,attr"`
+		Locations []struct {
+			File string `xml:"file,attr"`
+			Line int    `xml:"line,attr"`
+		} `xml:"location"`
+	} `xml:"issue"`
+}
+
+func (androidLintAnalyzer) Name() string         { return "android-lint" }
+func (androidLintAnalyzer) Version() string      { return "unknown" }
+func (androidLintAnalyzer) AcceptsUploads() bool { return true }
+
+func (androidLintAnalyzer) Run(ctx context.Context, ws *analysisWorkspace) ([]byte, error) {
+	raw, err := os.ReadFile(filepath.Join(ws.OutputDir, "android-lint-report.xml"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errNoReport
+	}
+	return raw, err
+}
+
+// androidLintSeverities maps Android Lint severities onto the common model.
+var androidLintSeverities = map[string]string{
+	"Fatal":       severityBlocker,
+	"Error":       severityCritical,
+	"Warning":     severityMajor,
+	"Information": severityInfo,
+}
+
+func (androidLintAnalyzer) Parse(raw []byte) ([]Finding, error) {
+	var report AndroidLintReport
+	if err := xml.Unmarshal(raw, &report); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal android lint report: %w", err)
+	}
+	findings := make([]Finding, 0, len(report.Issues))
+	for _, issue := range report.Issues {
+		// Issues the project configured as ignored are still listed.
+		if issue.Severity == "Ignore" {
+			continue
+		}
+		severity, ok := androidLintSeverities[issue.Severity]
+		if !ok {
+			severity = severityInfo
+		}
+		// Categories can be nested, as in "Usability:Typography"; the
+		// top-level one is kept.
+		category, _, _ := strings.Cut(issue.Category, ":")
+		finding := Finding{
+			RuleID:   issue.ID,
+			Severity: severity,
+			Category: strings.ToLower(category),
+			Message:  issue.Message,
+		}
+		if len(issue.Locations) > 0 {
+			finding.File = issue.Locations[0].File
+			finding.Line = issue.Locations[0].Line
+		}
+		findings = append(findings, finding)
+	}
+	return findings, nil
+}
+
+func (androidLintAnalyzer) Summarize(findings []Finding) AnalyzerSummary {
+	return summarizeFindings(findings)
+}
+
+func (androidLintAnalyzer) ReportVersion(raw []byte) string {
+	var report AndroidLintReport
+	if err := xml.Unmarshal(raw, &report); err != nil {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimPrefix(report.By, "lint"))
+}
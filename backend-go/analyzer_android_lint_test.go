@@ -0,0 +1,81 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAndroidLintParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    []Finding
+		wantErr bool
+	}{
+		{
+			name: "issues with severities and nested categories",
+			raw: `<?xml version="1.0" encoding="UTF-8"?>
+<issues format="6" by="lint 8.1.0">
+  <issue id="HardcodedText" severity="Warning" message="Hardcoded string" category="Internationalization">
+    <location file="app/src/main/res/layout/main.xml" line="12" column="9"/>
+  </issue>
+  <issue id="TypographyEllipsis" severity="Information" message="Replace &quot;...&quot;" category="Usability:Typography">
+    <location file="app/src/main/res/values/strings.xml" line="3"/>
+    <location file="app/src/main/res/values-de/strings.xml" line="4"/>
+  </issue>
+  <issue id="NewApi" severity="Fatal" message="Call requires API level 26" category="Correctness"/>
+  <issue id="MissingTranslation" severity="Error" message="Not translated" category="Correctness:Messages"/>
+  <issue id="Custom" severity="Unusual" message="m" category="Other"/>
+</issues>`,
+			want: []Finding{
+				{RuleID: "HardcodedText", Severity: severityMajor, Category: "internationalization", Message: "Hardcoded string", File: "app/src/main/res/layout/main.xml", Line: 12},
+				{RuleID: "TypographyEllipsis", Severity: severityInfo, Category: "usability", Message: `Replace "..."`, File: "app/src/main/res/values/strings.xml", Line: 3},
+				{RuleID: "NewApi", Severity: severityBlocker, Category: "correctness", Message: "Call requires API level 26"},
+				{RuleID: "MissingTranslation", Severity: severityCritical, Category: "correctness", Message: "Not translated"},
+				{RuleID: "Custom", Severity: severityInfo, Category: "other", Message: "m"},
+			},
+		},
+		{
+			name: "ignored issues are left out",
+			raw:  `<issues><issue id="UnusedResources" severity="Ignore" message="m" category="Performance"/></issues>`,
+			want: []Finding{},
+		},
+		{
+			name:    "other XML document",
+			raw:     `<checkstyle version="8.0"></checkstyle>`,
+			wantErr: true,
+		},
+		{
+			name:    "not XML",
+			raw:     `lint found 3 issues`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := androidLintAnalyzer{}.Parse([]byte(tt.raw))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAndroidLintReportVersion(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want string
+	}{
+		{`<issues format="6" by="lint 8.1.0"></issues>`, "8.1.0"},
+		{`<issues format="6"></issues>`, ""},
+		{`not xml`, ""},
+	}
+	for _, tt := range tests {
+		if got := (androidLintAnalyzer{}).ReportVersion([]byte(tt.raw)); got != tt.want {
+			t.Errorf("ReportVersion(%q) = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// detektAnalyzer reads the checkstyle report written by detekt in analyze.sh.
+type detektAnalyzer struct{}
+
+func init() {
+	registerAnalyzer(detektAnalyzer{})
+}
+
+func (detektAnalyzer) Name() string    { return "detekt" }
+func (detektAnalyzer) Version() string { return "unknown" }
+
+func (detektAnalyzer) Run(ctx context.Context, ws *analysisWorkspace) ([]byte, error) {
+	raw, err := os.ReadFile(filepath.Join(ws.OutputDir, "detekt-report.xml"))
+	if err != nil {
+		return nil, errors.New("detekt did not produce a report")
+	}
+	return raw, nil
+}
+
+// detektSeverities maps checkstyle severities onto the common model.
+var detektSeverities = map[string]string{
+	"error":   severityMajor,
+	"warning": severityMinor,
+	"info":    severityInfo,
+}
+
+func (detektAnalyzer) Parse(raw []byte) ([]Finding, error) {
+	findings, err := parseCheckstyleFindings(raw, detektSeverities, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal detekt report: %w", err)
+	}
+	for i := range findings {
+		findings[i].RuleID = strings.TrimPrefix(findings[i].RuleID, "detekt.")
+	}
+	return findings, nil
+}
+
+func (detektAnalyzer) Summarize(findings []Finding) AnalyzerSummary {
+	return summarizeFindings(findings)
+}
+
+// StoreResults keeps detekt_results filled for the Detekt endpoints and
+// analytics.
+func (detektAnalyzer) StoreResults(ctx context.Context, scanID string, raw []byte) error {
+	counts, err := parseDetektReport(string(raw))
+	if err != nil {
+		return err
+	}
+	_, err = dbPool.Exec(ctx, `
+        INSERT INTO detekt_results (scan_id, detekt_xml, error_issues, warning_issues, info_issues)
+        VALUES ($1, $2, $3, $4, $5)`,
+		scanID, string(raw), counts.ErrorIssues, counts.WarningIssues, counts.InfoIssues,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert detekt result: %w", err)
+	}
+	// The findings are already stored; a baseline that can't be applied
+	// leaves them all counted as new.
+	if err := applyDetektBaseline(ctx, scanID); err != nil {
+		log.Printf("Failed to apply detekt baseline to scan %s: %v", scanID, err)
+	}
+	return nil
+}
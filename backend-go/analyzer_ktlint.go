@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ktlintAnalyzer reads the checkstyle report written by ktlint in analyze.sh.
+type ktlintAnalyzer struct{}
+
+func init() {
+	registerAnalyzer(ktlintAnalyzer{})
+}
+
+func (ktlintAnalyzer) Name() string    { return "ktlint" }
+func (ktlintAnalyzer) Version() string { return "unknown" }
+
+func (ktlintAnalyzer) Run(ctx context.Context, ws *analysisWorkspace) ([]byte, error) {
+	raw, err := os.ReadFile(filepath.Join(ws.OutputDir, "ktlint-report.xml"))
+	if err != nil {
+		return nil, errors.New("ktlint did not produce a report")
+	}
+	return raw, nil
+}
+
+// ktlintSeverities maps checkstyle severities onto the common model. ktlint
+// reports every violation as an error, but they are formatting problems and
+// rank below Detekt's smells.
+var ktlintSeverities = map[string]string{
+	"error":   severityMinor,
+	"warning": severityMinor,
+	"info":    severityInfo,
+}
+
+func (ktlintAnalyzer) Parse(raw []byte) ([]Finding, error) {
+	findings, err := parseCheckstyleFindings(raw, ktlintSeverities, "formatting")
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal ktlint report: %w", err)
+	}
+	return findings, nil
+}
+
+func (ktlintAnalyzer) Summarize(findings []Finding) AnalyzerSummary {
+	return summarizeFindings(findings)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseCheckstyleFindings(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    []Finding
+		wantErr bool
+	}{
+		{
+			name: "errors of several files",
+			raw: `<?xml version="1.0" encoding="utf-8"?>
+<checkstyle version="8.0">
+  <file name="src/main/kotlin/App.kt">
+    <error line="3" column="1" severity="error" message="Unexpected blank line(s) before &quot;}&quot;" source="standard:no-blank-line-before-rbrace" />
+    <error line="7" column="5" severity="warning" message="Missing newline" source="standard:wrapping" />
+  </file>
+  <file name="src/main/kotlin/Util.kt">
+    <error line="1" column="1" severity="info" message="File must end with a newline" source="standard:final-newline" />
+  </file>
+</checkstyle>`,
+			want: []Finding{
+				{RuleID: "standard:no-blank-line-before-rbrace", Severity: severityMinor, Category: "formatting", Message: `Unexpected blank line(s) before "}"`, File: "src/main/kotlin/App.kt", Line: 3},
+				{RuleID: "standard:wrapping", Severity: severityMinor, Category: "formatting", Message: "Missing newline", File: "src/main/kotlin/App.kt", Line: 7},
+				{RuleID: "standard:final-newline", Severity: severityInfo, Category: "formatting", Message: "File must end with a newline", File: "src/main/kotlin/Util.kt", Line: 1},
+			},
+		},
+		{
+			name: "unknown severity becomes info",
+			raw:  `<checkstyle><file name="A.kt"><error line="2" severity="fatal" message="m" source="r" /></file></checkstyle>`,
+			want: []Finding{{RuleID: "r", Severity: severityInfo, Category: "formatting", Message: "m", File: "A.kt", Line: 2}},
+		},
+		{
+			name: "no findings",
+			raw:  `<checkstyle version="8.0"><file name="A.kt"></file></checkstyle>`,
+			want: []Finding{},
+		},
+		{
+			name:    "not XML",
+			raw:     `{"findings": []}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ktlintAnalyzer{}.Parse([]byte(tt.raw))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCheckstyleFindingsSeverities(t *testing.T) {
+	raw := []byte(`<checkstyle><file name="A.kt">
+  <error line="1" severity="error" message="e" source="detekt.LongMethod" />
+  <error line="2" severity="warning" message="w" source="detekt.MagicNumber" />
+</file></checkstyle>`)
+	got, err := parseCheckstyleFindings(raw, detektSeverities, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].Severity != severityMajor || got[1].Severity != severityMinor {
+		t.Errorf("parseCheckstyleFindings() = %+v, want a major and a minor finding", got)
+	}
+}
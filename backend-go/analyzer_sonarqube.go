@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// sonarQubeAnalyzer collects the analysis uploaded by SonarScanner in
+// analyze.sh from the SonarQube server.
+type sonarQubeAnalyzer struct{}
+
+func init() {
+	registerAnalyzer(sonarQubeAnalyzer{})
+}
+
+// sonarQubeReport is the raw report of the SonarQube analyzer: the responses
+// of the issues and measures APIs, kept verbatim.
+type sonarQubeReport struct {
+	Issues   json.RawMessage `json:"issues"`
+	Measures json.RawMessage `json:"measures"`
+}
+
+func (sonarQubeAnalyzer) Name() string    { return "sonarqube" }
+func (sonarQubeAnalyzer) Version() string { return "unknown" }
+
+func (sonarQubeAnalyzer) Run(ctx context.Context, ws *analysisWorkspace) ([]byte, error) {
+	sonarHostURL := "http://localhost:9000"
+	apiToken := os.Getenv("SONAR_API_TOKEN")
+	if apiToken == "" {
+		log.Println("Warning: SONAR_API_TOKEN is not set. Falling back to SONAR_LOGIN_TOKEN. This may cause permission issues.")
+		apiToken = os.Getenv("SONAR_LOGIN_TOKEN")
+	}
+
+	ws.SetPhase(scanPhaseSonarProcessing)
+	log.Printf("Waiting for SonarQube to process analysis for %s...", ws.SonarProjectKey)
+	if err := waitForSonarQubeAnalysis(ctx, ws.SonarProjectKey, ws.ScanID, sonarHostURL, apiToken, 300*time.Second); err != nil {
+		return nil, err
+	}
+
+	log.Printf("Fetching SonarQube issues and measures for %s...", ws.SonarProjectKey)
+	ws.Logs.info("Fetching SonarQube issues and measures.")
+	issuesJSON, err := fetchSonarQubeAPI(ctx, ws.SonarProjectKey, sonarHostURL, apiToken, "api/issues/search")
+	if err != nil {
+		log.Printf("Warning: Could not fetch SonarQube issues: %v", err)
+		return nil, errors.New("could not fetch SonarQube issues")
+	}
+	measuresJSON, err := fetchSonarQubeAPI(ctx, ws.SonarProjectKey, sonarHostURL, apiToken, "api/measures/component")
+	if err != nil {
+		log.Printf("Warning: Could not fetch SonarQube measures: %v", err)
+		return nil, errors.New("could not fetch SonarQube measures")
+	}
+	return json.Marshal(sonarQubeReport{Issues: json.RawMessage(issuesJSON), Measures: json.RawMessage(measuresJSON)})
+}
+
+func (sonarQubeAnalyzer) Parse(raw []byte) ([]Finding, error) {
+	var report sonarQubeReport
+	if err := json.Unmarshal(raw, &report); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal sonarqube report: %w", err)
+	}
+	var issues SonarQubeIssuesResponse
+	if err := json.Unmarshal(report.Issues, &issues); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal sonarqube issues: %w", err)
+	}
+	findings := make([]Finding, 0, len(issues.Issues))
+	for _, issue := range issues.Issues {
+		severity := strings.ToLower(issue.Severity)
+		if severity == "" {
+			severity = severityInfo
+		}
+		file := issue.Component
+		if _, path, ok := strings.Cut(issue.Component, ":"); ok {
+			file = path
+		}
+		findings = append(findings, Finding{
+			RuleID:   issue.Rule,
+			Severity: severity,
+			Category: strings.ToLower(issue.Type),
+			Message:  issue.Message,
+			File:     file,
+			Line:     issue.Line,
+		})
+	}
+	return findings, nil
+}
+
+func (sonarQubeAnalyzer) Summarize(findings []Finding) AnalyzerSummary {
+	return summarizeFindings(findings)
+}
+
+// StoreResults keeps sonarqube_results and the SonarQube metrics on scans
+// filled for the SonarQube endpoint and analytics.
+func (sonarQubeAnalyzer) StoreResults(ctx context.Context, scanID string, raw []byte) error {
+	var report sonarQubeReport
+	if err := json.Unmarshal(raw, &report); err != nil {
+		return fmt.Errorf("failed to unmarshal sonarqube report: %w", err)
+	}
+	sonarMetrics, err := parseSonarQubeMeasures(string(report.Measures))
+	if err != nil {
+		return err
+	}
+	_, err = dbPool.Exec(ctx, `
+        INSERT INTO sonarqube_results (scan_id, sonar_json, blocker_issues, critical_issues, major_issues, minor_issues, info_issues, code_smells, bugs, vulnerabilities)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		scanID, string(report.Issues), sonarMetrics.BlockerIssues, sonarMetrics.CriticalIssues,
+		sonarMetrics.MajorIssues, sonarMetrics.MinorIssues, sonarMetrics.InfoIssues, sonarMetrics.CodeSmells,
+		sonarMetrics.Bugs, sonarMetrics.Vulnerabilities,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert sonarqube result: %w", err)
+	}
+	_, err = dbPool.Exec(ctx, `
+        UPDATE scans SET lines_of_code = $1, maintainability_rating = $2, cognitive_complexity = $3
+        WHERE id = $4`,
+		sonarMetrics.LinesOfCode, sonarMetrics.MaintainabilityRating, sonarMetrics.CognitiveComplexity, scanID,
+	)
+	if err != nil {
+		log.Printf("Failed to update scans table for scanID %s: %v", scanID, err)
+	}
+	return nil
+}
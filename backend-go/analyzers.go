@@ -0,0 +1,438 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// Normalized severities of the common finding model, from most to least
+// severe. Each analyzer maps its tool's own levels onto these.
+const (
+	severityBlocker  = "blocker"
+	severityCritical = "critical"
+	severityMajor    = "major"
+	severityMinor    = "minor"
+	severityInfo     = "info"
+)
+
+// Finding is a single issue reported by an analyzer, in the model shared by
+// all tools.
+type Finding struct {
+	Analyzer string `json:"analyzer"`
+	RuleID   string `json:"rule_id"`
+	Severity string `json:"severity"`
+	Category string `json:"category,omitempty"`
+	Message  string `json:"message,omitempty"`
+	File     string `json:"file,omitempty"`
+	Line     int    `json:"line,omitempty"`
+	// Fingerprint identifies the issue across scans; see findingFingerprint.
+	Fingerprint string `json:"fingerprint,omitempty"`
+	// BaselineSuppressed is set on findings in the project's baseline.
+	BaselineSuppressed bool `json:"baseline_suppressed,omitempty"`
+}
+
+// AnalyzerSummary aggregates the findings of one analyzer for a scan.
+type AnalyzerSummary struct {
+	Total      int            `json:"total"`
+	BySeverity map[string]int `json:"by_severity"`
+	ByCategory map[string]int `json:"by_category,omitempty"`
+}
+
+// analysisWorkspace is what analyzers get to produce their report once the
+// analysis container has finished.
+type analysisWorkspace struct {
+	ScanID          string
+	SonarProjectKey string
+	// OutputDir is the directory mounted at /data in the analysis container.
+	OutputDir string
+	// RepoDir is where the tools saw the repository, which paths in their
+	// reports are made relative to.
+	RepoDir string
+	// ToolVersions are the versions analyze.sh found the tools at, by tool.
+	ToolVersions map[string]string
+	SetPhase     func(phase string)
+	Logs         *scanLogHub
+}
+
+// Analyzer is a code analysis tool whose results DP stores per scan. New
+// tools are added by implementing it and calling registerAnalyzer from an
+// init function.
+type Analyzer interface {
+	// Name identifies the analyzer in storage and API responses.
+	Name() string
+	// Version is the version of the underlying tool, or "unknown" when it
+	// can't be told from outside the analysis image. The version analyze.sh
+	// reports for the tool takes its place.
+	Version() string
+	// Run produces the raw report for a scan, or errNoReport when the tool
+	// does not apply. Tools executed by analyze.sh only read their report
+	// from ws.OutputDir here.
+	Run(ctx context.Context, ws *analysisWorkspace) ([]byte, error)
+	// Parse converts a raw report into findings.
+	Parse(raw []byte) ([]Finding, error)
+	// Summarize aggregates findings for quick lookups.
+	Summarize(findings []Finding) AnalyzerSummary
+}
+
+// analyzerResultStorer is implemented by analyzers that keep tool-specific
+// tables in addition to the common findings. Their raw report is then not
+// duplicated into analyzer_results.
+type analyzerResultStorer interface {
+	StoreResults(ctx context.Context, scanID string, raw []byte) error
+}
+
+// reportVersioner is implemented by analyzers whose reports carry the
+// version of the tool that produced them, e.g. uploaded ones. It takes
+// precedence over Analyzer.Version.
+type reportVersioner interface {
+	ReportVersion(raw []byte) string
+}
+
+// reportUploader is implemented by analyzers whose reports may also be
+// produced outside the analysis container and uploaded to a scan.
+type reportUploader interface {
+	AcceptsUploads() bool
+}
+
+// errNoReport is returned by Run when the tool does not apply to the
+// repository or is not run in the container. Unlike other errors it does not
+// make the scan partial.
+var errNoReport = errors.New("no report for this scan")
+
+var registeredAnalyzers []Analyzer
+
+// registerAnalyzer adds an analyzer to the pipeline. Analyzers run in
+// registration order.
+func registerAnalyzer(a Analyzer) {
+	for _, existing := range registeredAnalyzers {
+		if existing.Name() == a.Name() {
+			panic(fmt.Sprintf("analyzer %q registered twice", a.Name()))
+		}
+	}
+	registeredAnalyzers = append(registeredAnalyzers, a)
+}
+
+func findAnalyzer(name string) (Analyzer, bool) {
+	for _, a := range registeredAnalyzers {
+		if a.Name() == name {
+			return a, true
+		}
+	}
+	return nil, false
+}
+
+// analyzerOutput is the result of running one analyzer for a scan.
+type analyzerOutput struct {
+	Analyzer Analyzer
+	Version  string
+	Raw      []byte
+	Findings []Finding
+	Summary  AnalyzerSummary
+}
+
+// analysisResults holds the outputs of the analyzers that produced a report,
+// plus the reasons the others did not.
+type analysisResults struct {
+	Outputs  []analyzerOutput
+	Failures []scanFailure
+	// ReusedFrom is the scan whose results were copied instead of running
+	// the analysis, if any.
+	ReusedFrom string
+}
+
+// runAnalyzers runs every registered analyzer against the workspace of a
+// finished analysis container, then ingests the SARIF reports left there by
+// other tools. An analyzer that fails is recorded as a failure of the phase
+// named after it; the others still run. Only a done ctx stops the loop.
+func runAnalyzers(ctx context.Context, ws *analysisWorkspace) (analysisResults, error) {
+	var results analysisResults
+	for _, a := range registeredAnalyzers {
+		raw, err := a.Run(ctx, ws)
+		if ctx.Err() != nil {
+			return analysisResults{}, ctx.Err()
+		}
+		if errors.Is(err, errNoReport) {
+			continue
+		}
+		if err != nil {
+			log.Printf("Warning: %s produced no report for scan %s: %v", a.Name(), ws.ScanID, err)
+			results.Failures = append(results.Failures, scanFailure{Phase: a.Name(), Reason: failureReason(err)})
+			continue
+		}
+		out, err := parseAnalyzerReport(a, raw, ws.RepoDir)
+		if err != nil {
+			log.Printf("Warning: Failed to parse %s report for scan %s: %v", a.Name(), ws.ScanID, err)
+			results.Failures = append(results.Failures, scanFailure{Phase: a.Name(), Reason: "Report could not be parsed: " + err.Error()})
+			continue
+		}
+		if _, ok := a.(reportVersioner); !ok {
+			if version := toolVersion(ws.ToolVersions, a.Name()); version != "" {
+				out.Version = version
+			}
+		}
+		results.Outputs = append(results.Outputs, out)
+	}
+
+	sarifOutputs, sarifFailures := collectSarifReports(ws)
+	results.Outputs = append(results.Outputs, sarifOutputs...)
+	results.Failures = append(results.Failures, sarifFailures...)
+	return results, nil
+}
+
+// parseAnalyzerReport turns the raw report of an analyzer into its output.
+// File paths under repoDir are made relative to it; repoDir is empty for
+// reports made elsewhere.
+func parseAnalyzerReport(a Analyzer, raw []byte, repoDir string) (analyzerOutput, error) {
+	findings, err := a.Parse(raw)
+	if err != nil {
+		return analyzerOutput{}, err
+	}
+	for i := range findings {
+		findings[i].Analyzer = a.Name()
+		findings[i].File = repoRelativePath(findings[i].File, repoDir)
+	}
+	version := a.Version()
+	if v, ok := a.(reportVersioner); ok {
+		if reported := v.ReportVersion(raw); reported != "" {
+			version = reported
+		}
+	}
+	return analyzerOutput{
+		Analyzer: a,
+		Version:  version,
+		Raw:      raw,
+		Findings: findings,
+		Summary:  a.Summarize(findings),
+	}, nil
+}
+
+// repoRelativePath makes a path a tool reported relative to the repository
+// at repoDir. Paths outside it, and relative ones, are left as they are.
+func repoRelativePath(file, repoDir string) string {
+	if repoDir == "" {
+		return file
+	}
+	prefix := strings.TrimSuffix(filepath.ToSlash(repoDir), "/") + "/"
+	return strings.TrimPrefix(file, prefix)
+}
+
+// summarizeFindings is the default Summarize implementation: counts by
+// severity and category.
+func summarizeFindings(findings []Finding) AnalyzerSummary {
+	summary := AnalyzerSummary{Total: len(findings), BySeverity: map[string]int{}, ByCategory: map[string]int{}}
+	for _, f := range findings {
+		summary.BySeverity[f.Severity]++
+		if f.Category != "" {
+			summary.ByCategory[f.Category]++
+		}
+	}
+	return summary
+}
+
+// parseCheckstyleFindings reads a checkstyle XML report, the format shared by
+// Detekt and ktlint. Severities missing from the map become info.
+func parseCheckstyleFindings(raw []byte, severities map[string]string, category string) ([]Finding, error) {
+	var report DetektReport
+	if err := xml.Unmarshal(raw, &report); err != nil {
+		return nil, err
+	}
+	findings := make([]Finding, 0)
+	for _, file := range report.Files {
+		for _, e := range file.Errors {
+			severity, ok := severities[e.Severity]
+			if !ok {
+				severity = severityInfo
+			}
+			findings = append(findings, Finding{
+				RuleID:   e.Source,
+				Severity: severity,
+				Category: category,
+				Message:  e.Message,
+				File:     file.Name,
+				Line:     e.Line,
+			})
+		}
+	}
+	return findings, nil
+}
+
+// storeScanResults saves the analyzer outputs of a scan. It returns the
+// reasons any output could not be stored and whether anything was stored.
+func storeScanResults(ctx context.Context, scanID string, results analysisResults) ([]scanFailure, bool) {
+	var failures []scanFailure
+	storedAny := false
+	for _, out := range results.Outputs {
+		if err := storeAnalyzerOutput(ctx, scanID, out); err != nil {
+			log.Printf("Failed to store %s results for scan %s: %v", out.Analyzer.Name(), scanID, err)
+			failures = append(failures, scanFailure{Phase: scanPhaseIngesting, Reason: fmt.Sprintf("%s results could not be saved.", out.Analyzer.Name())})
+			continue
+		}
+		storedAny = true
+	}
+	return failures, storedAny
+}
+
+func storeAnalyzerOutput(ctx context.Context, scanID string, out analyzerOutput) error {
+	name := out.Analyzer.Name()
+	summaryJSON, err := json.Marshal(out.Summary)
+	if err != nil {
+		return fmt.Errorf("failed to encode summary: %w", err)
+	}
+	var rawReport *string
+	storer, hasOwnTables := out.Analyzer.(analyzerResultStorer)
+	if !hasOwnTables {
+		raw := string(out.Raw)
+		rawReport = &raw
+	}
+
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// An uploaded report replaces the earlier one of the same analyzer.
+	for _, table := range []string{"analyzer_results", "scan_findings"} {
+		if _, err := tx.Exec(ctx, "DELETE FROM "+table+" WHERE scan_id = $1 AND analyzer = $2", scanID, name); err != nil {
+			return fmt.Errorf("failed to clear %s: %w", table, err)
+		}
+	}
+
+	_, err = tx.Exec(ctx, `
+        INSERT INTO analyzer_results (scan_id, analyzer, analyzer_version, raw_report, summary, total_findings)
+        VALUES ($1, $2, $3, $4, $5, $6)`,
+		scanID, name, out.Version, rawReport, summaryJSON, out.Summary.Total)
+	if err != nil {
+		return fmt.Errorf("failed to insert analyzer result: %w", err)
+	}
+
+	rows := make([][]any, 0, len(out.Findings))
+	for _, f := range out.Findings {
+		var line *int
+		if f.Line > 0 {
+			line = &f.Line
+		}
+		rows = append(rows, []any{scanID, name, f.RuleID, f.Severity, nullIfEmpty(f.Category), nullIfEmpty(f.Message), nullIfEmpty(f.File), line, findingFingerprint(f)})
+	}
+	_, err = tx.CopyFrom(ctx, pgx.Identifier{"scan_findings"},
+		[]string{"scan_id", "analyzer", "rule_id", "severity", "category", "message", "file_path", "line", "fingerprint"},
+		pgx.CopyFromRows(rows))
+	if err != nil {
+		return fmt.Errorf("failed to insert findings: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	if hasOwnTables {
+		return storer.StoreResults(ctx, scanID, out.Raw)
+	}
+	return nil
+}
+
+func nullIfEmpty(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// topRulesByAnalyzer returns, for every analyzer with findings in a scan, its
+// limit most frequent rules.
+func topRulesByAnalyzer(ctx context.Context, scanID string, limit int) (map[string][]RuleBreakdown, error) {
+	rows, err := dbPool.Query(ctx, `
+        SELECT analyzer, rule_id, issue_count FROM (
+            SELECT analyzer, rule_id, COUNT(*) AS issue_count,
+                   ROW_NUMBER() OVER (PARTITION BY analyzer ORDER BY COUNT(*) DESC, rule_id) AS rank
+            FROM scan_findings
+            WHERE scan_id = $1
+            GROUP BY analyzer, rule_id
+        ) ranked
+        WHERE rank <= $2
+        ORDER BY analyzer, rank`, scanID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := make(map[string][]RuleBreakdown)
+	for rows.Next() {
+		var analyzer string
+		var rule RuleBreakdown
+		if err := rows.Scan(&analyzer, &rule.RuleName, &rule.IssueCount); err != nil {
+			return nil, err
+		}
+		rules[analyzer] = append(rules[analyzer], rule)
+	}
+	return rules, rows.Err()
+}
+
+// getScanFindingsHandler returns the findings of a scan in the common model,
+// with the summary of every analyzer that produced a report. The optional
+// "analyzer", "severity" and "baseline" ("new" or "suppressed") query
+// parameters filter the findings.
+func getScanFindingsHandler(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	scanId := c.Param("scanId")
+	ctx := context.Background()
+
+	var exists bool
+	err := dbPool.QueryRow(ctx, "SELECT EXISTS(SELECT 1 FROM scans WHERE id = $1 AND user_id = $2)", scanId, userID.(string)).Scan(&exists)
+	if err != nil || !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Scan not found"})
+		return
+	}
+
+	analyzers := make([]gin.H, 0)
+	rows, err := dbPool.Query(ctx, `
+        SELECT analyzer, analyzer_version, summary FROM analyzer_results
+        WHERE scan_id = $1 ORDER BY analyzer`, scanId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch analyzer results"})
+		return
+	}
+	for rows.Next() {
+		var name, version string
+		var summary AnalyzerSummary
+		if err := rows.Scan(&name, &version, &summary); err != nil {
+			log.Printf("Error scanning analyzer result row: %v", err)
+			continue
+		}
+		analyzers = append(analyzers, gin.H{"name": name, "version": version, "summary": summary})
+	}
+	rows.Close()
+
+	rows, err = dbPool.Query(ctx, `
+        SELECT analyzer, rule_id, severity, COALESCE(category, ''), COALESCE(message, ''), COALESCE(file_path, ''), COALESCE(line, 0),
+               COALESCE(fingerprint, ''), baseline_suppressed
+        FROM scan_findings
+        WHERE scan_id = $1 AND ($2 = '' OR analyzer = $2) AND ($3 = '' OR severity = $3)
+          AND ($4 = '' OR baseline_suppressed = ($4 = 'suppressed'))
+        ORDER BY analyzer, file_path, line`, scanId, c.Query("analyzer"), c.Query("severity"), c.Query("baseline"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch findings"})
+		return
+	}
+	defer rows.Close()
+	findings := make([]Finding, 0)
+	for rows.Next() {
+		var f Finding
+		if err := rows.Scan(&f.Analyzer, &f.RuleID, &f.Severity, &f.Category, &f.Message, &f.File, &f.Line,
+			&f.Fingerprint, &f.BaselineSuppressed); err != nil {
+			log.Printf("Error scanning finding row: %v", err)
+			continue
+		}
+		findings = append(findings, f)
+	}
+	c.JSON(http.StatusOK, gin.H{"analyzers": analyzers, "findings": findings})
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestRepoRelativePath(t *testing.T) {
+	tests := []struct {
+		file    string
+		repoDir string
+		want    string
+	}{
+		{"/data/repo/src/App.kt", "/data/repo", "src/App.kt"},
+		{"/data/repo/src/App.kt", "/data/repo/", "src/App.kt"},
+		{"/tmp/scan-output-1/repo/src/App.kt", "/tmp/scan-output-1/repo", "src/App.kt"},
+		{"/data/repo/src/App.kt", "/tmp/scan-output-1/repo", "/data/repo/src/App.kt"},
+		{"/data/repository/App.kt", "/data/repo", "/data/repository/App.kt"},
+		{"src/App.kt", "/data/repo", "src/App.kt"},
+		{"/data/repo/src/App.kt", "", "/data/repo/src/App.kt"},
+		{"", "/data/repo", ""},
+	}
+	for _, tt := range tests {
+		if got := repoRelativePath(tt.file, tt.repoDir); got != tt.want {
+			t.Errorf("repoRelativePath(%q, %q) = %q, want %q", tt.file, tt.repoDir, got, tt.want)
+		}
+	}
+}
+
+func TestRunnerRepoDir(t *testing.T) {
+	if got := (dockerRunner{}).repoDir("/tmp/scan-output-1"); got != "/data/repo" {
+		t.Errorf("docker runner repoDir = %q, want the container path", got)
+	}
+	if got := (localRunner{}).repoDir("/tmp/scan-output-1"); got != filepath.Join("/tmp/scan-output-1", "repo") {
+		t.Errorf("local runner repoDir = %q, want the clone under the output dir", got)
+	}
+}
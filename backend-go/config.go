@@ -0,0 +1,83 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/go-units"
+)
+
+// getEnvInt reads an integer environment variable, falling back to def when
+// it is unset or invalid.
+func getEnvInt(name string, def int) int {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return def
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil {
+		log.Printf("Warning: invalid value %q for %s, using default %d", raw, name, def)
+		return def
+	}
+	return value
+}
+
+// getEnvDuration reads a duration environment variable such as "30s" or
+// "10m", falling back to def when it is unset or invalid.
+func getEnvDuration(name string, def time.Duration) time.Duration {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return def
+	}
+	value, err := time.ParseDuration(raw)
+	if err != nil {
+		log.Printf("Warning: invalid value %q for %s, using default %s", raw, name, def)
+		return def
+	}
+	return value
+}
+
+// getEnvBytes reads a size environment variable such as "512m" or "4g",
+// falling back to def when it is unset or invalid.
+func getEnvBytes(name string, def int64) int64 {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return def
+	}
+	value, err := units.RAMInBytes(raw)
+	if err != nil {
+		log.Printf("Warning: invalid size %q for %s, using default %d", raw, name, def)
+		return def
+	}
+	return value
+}
+
+// getEnvFloat reads a floating-point environment variable, falling back to
+// def when it is unset or invalid.
+func getEnvFloat(name string, def float64) float64 {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return def
+	}
+	value, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		log.Printf("Warning: invalid value %q for %s, using default %g", raw, name, def)
+		return def
+	}
+	return value
+}
+
+// getEnvList reads a comma-separated environment variable, dropping empty
+// items. It returns nil when the variable is unset.
+func getEnvList(name string) []string {
+	var items []string
+	for _, item := range strings.Split(os.Getenv(name), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"golang.org/x/crypto/ssh"
+)
+
+// Kinds of project credentials, stored in project_credentials.kind and
+// passed to analyze.sh as GIT_CREDENTIALS_TYPE.
+const (
+	credentialHTTPSToken = "https_token"
+	credentialSSHKey     = "ssh_key"
+)
+
+// defaultTokenUsername is sent with an HTTPS token when the user gives no
+// username. GitHub and GitLab accept any non-empty username with a token.
+const defaultTokenUsername = "x-access-token"
+
+// cloneSecretsMountPath is where the clone credentials of a scan are mounted
+// in the analysis container.
+const cloneSecretsMountPath = "/secrets"
+
+var errCredentialsKeyMissing = errors.New("CREDENTIALS_ENCRYPTION_KEY is not set")
+
+// projectCredential is the decrypted credential of a project. It is only
+// ever held in memory and in the secrets directory of a running scan.
+type projectCredential struct {
+	Kind     string
+	Username string // HTTPS tokens only
+	Secret   string
+}
+
+// credentialsAEAD builds the cipher from CREDENTIALS_ENCRYPTION_KEY, a
+// base64-encoded 32-byte key.
+func credentialsAEAD() (cipher.AEAD, error) {
+	raw := os.Getenv("CREDENTIALS_ENCRYPTION_KEY")
+	if raw == "" {
+		return nil, errCredentialsKeyMissing
+	}
+	key, err := base64.StdEncoding.DecodeString(raw)
+	if err != nil || len(key) != 32 {
+		return nil, errors.New("CREDENTIALS_ENCRYPTION_KEY must be 32 bytes, base64-encoded")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptCredential seals a secret with AES-256-GCM. The project ID is bound
+// as additional data so a ciphertext can't be moved to another project.
+func encryptCredential(projectID, secret string) ([]byte, error) {
+	aead, err := credentialsAEAD()
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, []byte(secret), []byte(projectID)), nil
+}
+
+func decryptCredential(projectID string, sealed []byte) (string, error) {
+	aead, err := credentialsAEAD()
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return "", errors.New("stored credential is corrupt")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, []byte(projectID))
+	if err != nil {
+		return "", errors.New("stored credential could not be decrypted")
+	}
+	return string(plaintext), nil
+}
+
+// loadProjectCredential returns the decrypted credential of a project, or nil
+// when it has none.
+func loadProjectCredential(ctx context.Context, projectID string) (*projectCredential, error) {
+	var cred projectCredential
+	var username *string
+	var sealed []byte
+	err := dbPool.QueryRow(ctx, `
+        SELECT kind, username, secret_encrypted FROM project_credentials WHERE project_id = $1`, projectID,
+	).Scan(&cred.Kind, &username, &sealed)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load credentials of project %s: %w", projectID, err)
+	}
+	if username != nil {
+		cred.Username = *username
+	}
+	cred.Secret, err = decryptCredential(projectID, sealed)
+	if err != nil {
+		return nil, fmt.Errorf("credentials of project %s: %w", projectID, err)
+	}
+	return &cred, nil
+}
+
+// cloneSecrets is the directory holding a scan's credentials while the
+// repository is cloned. It is removed as soon as analyze.sh moves past the
+// cloning phase, and at the latest when the scan ends.
+type cloneSecrets struct {
+	Dir     string
+	Kind    string
+	redact  []string
+	removed sync.Once
+}
+
+func writeCloneSecrets(cred *projectCredential) (*cloneSecrets, error) {
+	dir, err := os.MkdirTemp("", "scan-secrets-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create secrets dir: %w", err)
+	}
+	files := map[string]string{}
+	switch cred.Kind {
+	case credentialHTTPSToken:
+		files["username"] = cred.Username
+		files["token"] = cred.Secret
+	case credentialSSHKey:
+		files["ssh_key"] = strings.TrimSpace(cred.Secret) + "\n"
+	default:
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("unknown credential kind %q", cred.Kind)
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			os.RemoveAll(dir)
+			return nil, fmt.Errorf("failed to write secrets: %w", err)
+		}
+	}
+	// Only the secret is masked. Usernames show up in URLs and messages
+	// that say nothing secret, and masking a short one would garble them.
+	return &cloneSecrets{Dir: dir, Kind: cred.Kind, redact: secretFragments(cred.Secret)}, nil
+}
+
+// remove deletes the secrets from disk. It is safe to call more than once
+// and on a nil receiver.
+func (s *cloneSecrets) remove() {
+	if s == nil {
+		return
+	}
+	s.removed.Do(func() {
+		if err := os.RemoveAll(s.Dir); err != nil {
+			log.Printf("Failed to remove clone secrets %s: %v", s.Dir, err)
+		}
+	})
+}
+
+// redactLine masks any part of the secret that shows up in the container
+// output before it is logged or stored.
+func (s *cloneSecrets) redactLine(line string) string {
+	if s == nil {
+		return line
+	}
+	for _, fragment := range s.redact {
+		line = strings.ReplaceAll(line, fragment, "[REDACTED]")
+	}
+	return line
+}
+
+// secretFragments returns the strings to mask for a secret: each non-empty
+// line of it, however short, longest first so that a fragment contained in
+// another doesn't keep the longer one from matching. The PEM armor lines of
+// a key are not secret and are left alone.
+func secretFragments(secret string) []string {
+	var fragments []string
+	for _, line := range strings.Split(secret, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "-----") && !slices.Contains(fragments, line) {
+			fragments = append(fragments, line)
+		}
+	}
+	sort.SliceStable(fragments, func(i, j int) bool { return len(fragments[i]) > len(fragments[j]) })
+	return fragments
+}
+
+func getProjectCredentialsHandler(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	projectID := c.Param("id")
+
+	var exists bool
+	err := dbPool.QueryRow(context.Background(), "SELECT EXISTS(SELECT 1 FROM projects WHERE id=$1 AND user_id=$2)", projectID, userID.(string)).Scan(&exists)
+	if err != nil || !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+
+	var kind string
+	var username *string
+	var updatedAt time.Time
+	err = dbPool.QueryRow(context.Background(),
+		"SELECT kind, username, updated_at FROM project_credentials WHERE project_id = $1", projectID,
+	).Scan(&kind, &username, &updatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusOK, gin.H{"configured": false})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch credentials"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"configured": true, "type": kind, "username": username, "updatedAt": updatedAt})
+}
+
+// putProjectCredentialsHandler attaches an HTTPS token or an SSH deploy key
+// to a project, replacing any earlier one. The secret is never returned.
+func putProjectCredentialsHandler(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	projectID := c.Param("id")
+
+	var req struct {
+		Type       string `json:"type" binding:"required"`
+		Username   string `json:"username"`
+		Token      string `json:"token"`
+		PrivateKey string `json:"privateKey"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data."})
+		return
+	}
+
+	var username *string
+	var secret string
+	switch req.Type {
+	case credentialHTTPSToken:
+		if strings.TrimSpace(req.Token) == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "token is required"})
+			return
+		}
+		secret = strings.TrimSpace(req.Token)
+		name := strings.TrimSpace(req.Username)
+		if name == "" {
+			name = defaultTokenUsername
+		}
+		username = &name
+	case credentialSSHKey:
+		if _, err := ssh.ParseRawPrivateKey([]byte(req.PrivateKey)); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "privateKey must be an unencrypted SSH private key"})
+			return
+		}
+		secret = req.PrivateKey
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "type must be \"https_token\" or \"ssh_key\""})
+		return
+	}
+
+	var exists bool
+	err := dbPool.QueryRow(context.Background(), "SELECT EXISTS(SELECT 1 FROM projects WHERE id=$1 AND user_id=$2)", projectID, userID.(string)).Scan(&exists)
+	if err != nil || !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+
+	sealed, err := encryptCredential(projectID, secret)
+	if err != nil {
+		log.Printf("Failed to encrypt credentials of project %s: %v", projectID, err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Credential storage is not configured on this server"})
+		return
+	}
+	_, err = dbPool.Exec(context.Background(), `
+        INSERT INTO project_credentials (project_id, kind, username, secret_encrypted)
+        VALUES ($1, $2, $3, $4)
+        ON CONFLICT (project_id) DO UPDATE
+        SET kind = EXCLUDED.kind, username = EXCLUDED.username,
+            secret_encrypted = EXCLUDED.secret_encrypted, updated_at = NOW()`,
+		projectID, req.Type, username, sealed)
+	if err != nil {
+		log.Printf("Failed to store credentials of project %s: %v", projectID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not save credentials"})
+		return
+	}
+	getProjectCredentialsHandler(c)
+}
+
+func deleteProjectCredentialsHandler(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	projectID := c.Param("id")
+
+	tag, err := dbPool.Exec(context.Background(), `
+        DELETE FROM project_credentials pc
+        USING projects p
+        WHERE pc.project_id = p.id AND p.id = $1 AND p.user_id = $2`,
+		projectID, userID.(string))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not delete credentials"})
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No credentials found for this project"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"configured": false})
+}
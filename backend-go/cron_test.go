@@ -0,0 +1,118 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	tests := []struct {
+		expr    string
+		wantErr bool
+	}{
+		{expr: "* * * * *"},
+		{expr: "30 2 * * 1-5"},
+		{expr: "*/15 0-6,22,23 1,15 */2 0,7"},
+		{expr: "0-30/10 * * * *"},
+		{expr: "5/20 * * * *"},
+		{expr: "  0 0 29 2 *  "},
+		{expr: "@daily"},
+		{expr: "Weekly"},
+		{expr: "", wantErr: true},
+		{expr: "* * * *", wantErr: true},
+		{expr: "* * * * * *", wantErr: true},
+		{expr: "60 * * * *", wantErr: true},
+		{expr: "* 24 * * *", wantErr: true},
+		{expr: "* * 0 * *", wantErr: true},
+		{expr: "* * * 13 *", wantErr: true},
+		{expr: "* * * * 8", wantErr: true},
+		{expr: "5-1 * * * *", wantErr: true},
+		{expr: "*/0 * * * *", wantErr: true},
+		{expr: "*/x * * * *", wantErr: true},
+		{expr: "a * * * *", wantErr: true},
+		{expr: "1- * * * *", wantErr: true},
+		{expr: "@yearly", wantErr: true},
+	}
+	for _, tt := range tests {
+		_, err := parseCron(tt.expr)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseCron(%q) error = %v, want error %v", tt.expr, err, tt.wantErr)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("no time zone data:", err)
+	}
+	utc := func(s string) time.Time {
+		v, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	tests := []struct {
+		name string
+		expr string
+		from time.Time
+		want time.Time // zero for never
+	}{
+		{"every minute", "* * * * *", utc("2024-05-01T10:00:30Z"), utc("2024-05-01T10:01:00Z")},
+		{"strictly after", "0 10 * * *", utc("2024-05-01T10:00:00Z"), utc("2024-05-02T10:00:00Z")},
+		{"step", "*/15 * * * *", utc("2024-05-01T10:16:00Z"), utc("2024-05-01T10:30:00Z")},
+		{"hourly preset", "@hourly", utc("2024-05-01T10:59:59Z"), utc("2024-05-01T11:00:00Z")},
+		{"daily preset", "daily", utc("2024-12-31T23:59:00Z"), utc("2025-01-01T00:00:00Z")},
+		{"weekly preset", "@weekly", utc("2024-05-01T10:00:00Z"), utc("2024-05-05T00:00:00Z")},
+		{"weekdays", "30 2 * * 1-5", utc("2024-05-03T03:00:00Z"), utc("2024-05-06T02:30:00Z")},
+		{"sunday as 7", "0 0 * * 7", utc("2024-05-01T00:00:00Z"), utc("2024-05-05T00:00:00Z")},
+		{"either day field", "0 0 13 * 5", utc("2024-05-01T00:00:00Z"), utc("2024-05-03T00:00:00Z")},
+		{"either day field, day of month first", "0 0 2 * 5", utc("2024-05-01T00:00:00Z"), utc("2024-05-02T00:00:00Z")},
+		{"both day fields with star", "0 0 * 6 5", utc("2024-05-01T00:00:00Z"), utc("2024-06-07T00:00:00Z")},
+		{"31st skips short months", "0 0 31 * *", utc("2024-04-01T00:00:00Z"), utc("2024-05-31T00:00:00Z")},
+		{"February 29th", "0 0 29 2 *", utc("2024-03-01T00:00:00Z"), utc("2028-02-29T00:00:00Z")},
+		{"February 30th never", "0 0 30 2 *", utc("2024-01-01T00:00:00Z"), time.Time{}},
+		{"in the location", "0 9 * * *", utc("2024-05-01T06:00:00Z").In(berlin), utc("2024-05-01T07:00:00Z")},
+		// On 2024-03-31 Berlin's clocks go from 02:00 CET to 03:00 CEST.
+		{"skipped time runs after the change", "30 2 * * *", utc("2024-03-31T00:00:00Z").In(berlin), utc("2024-03-31T01:30:00Z")},
+		{"after the skipped time", "30 2 * * *", utc("2024-03-31T01:30:00Z").In(berlin), utc("2024-04-01T00:30:00Z")},
+		{"hourly across the gap", "0 * * * *", utc("2024-03-31T00:30:00Z").In(berlin), utc("2024-03-31T01:00:00Z")},
+		// On 2024-10-27 they go from 03:00 CEST back to 02:00 CET.
+		{"repeated time runs once", "30 2 * * *", utc("2024-10-27T00:30:00Z").In(berlin), utc("2024-10-28T01:30:00Z")},
+		{"from within the repeated hour", "30 2 * * *", utc("2024-10-27T01:10:00Z").In(berlin), utc("2024-10-27T01:30:00Z")},
+		{"repeated hour not run again", "*/30 * * * *", utc("2024-10-27T00:30:00Z").In(berlin), utc("2024-10-27T02:00:00Z")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := parseCron(tt.expr)
+			if err != nil {
+				t.Fatalf("parseCron(%q): %v", tt.expr, err)
+			}
+			got := s.next(tt.from)
+			if !got.Equal(tt.want) || got.IsZero() != tt.want.IsZero() {
+				t.Errorf("next(%q, %v) = %v, want %v", tt.expr, tt.from, got, tt.want)
+			}
+			if !got.IsZero() && got.Location() != tt.from.Location() {
+				t.Errorf("next returned a time in %v, want %v", got.Location(), tt.from.Location())
+			}
+		})
+	}
+}
+
+func TestNextScheduledRun(t *testing.T) {
+	from := time.Date(2024, 5, 1, 6, 0, 0, 0, time.UTC)
+	if _, err := nextScheduledRun("0 9 * * *", "Not/AZone", from); err == nil {
+		t.Error("unknown timezone accepted")
+	}
+	if _, err := nextScheduledRun("0 0 30 2 *", "UTC", from); err == nil {
+		t.Error("schedule that never runs accepted")
+	}
+	if _, err := nextScheduledRun("bogus", "UTC", from); err == nil {
+		t.Error("invalid expression accepted")
+	}
+	got, err := nextScheduledRun("0 9 * * *", "UTC", from)
+	if want := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC); err != nil || !got.Equal(want) {
+		t.Errorf("nextScheduledRun = %v, %v; want %v", got, err, want)
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// findingFingerprint identifies a finding across scans: its rule, file and
+// message, but not its line, so that code moving around a file doesn't make
+// an old issue look new.
+func findingFingerprint(f Finding) string {
+	return sha256Hex([]byte(f.RuleID + "\x00" + f.File + "\x00" + f.Message))[:40]
+}
+
+// detektBaseline is the set of detekt issues a project has accepted. Later
+// scans still report these issues, but count them apart from new ones.
+//
+// It is applied by DP rather than passed to detekt as a baseline file:
+// detekt leaves the issues of its baseline out of the report, so they
+// couldn't be counted, and the IDs of a detekt baseline hold signatures of
+// the code around an issue that its checkstyle report doesn't include.
+type detektBaseline struct {
+	SourceScanID *string   `json:"scanId"`
+	IssueCount   int       `json:"issueCount"`
+	CreatedAt    time.Time `json:"createdAt"`
+	Issues       []Finding `json:"issues"`
+	fingerprints []string
+}
+
+// loadDetektBaseline returns the active baseline of a project, or nil when
+// it has none.
+func loadDetektBaseline(ctx context.Context, projectID string) (*detektBaseline, error) {
+	var b detektBaseline
+	err := dbPool.QueryRow(ctx, `
+        SELECT source_scan_id, issue_count, created_at, issues, fingerprints FROM detekt_baselines WHERE project_id = $1`, projectID,
+	).Scan(&b.SourceScanID, &b.IssueCount, &b.CreatedAt, &b.Issues, &b.fingerprints)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load detekt baseline of project %s: %w", projectID, err)
+	}
+	return &b, nil
+}
+
+// matchBaseline returns the indexes of the findings, given by fingerprint,
+// that the baseline covers. Each fingerprint in the baseline covers one
+// finding, the earliest first, so an issue that occurs more often than in
+// the baseline is new for the extra occurrences. Findings without a
+// fingerprint are never covered.
+func matchBaseline(baseline, findings []string) []int {
+	remaining := make(map[string]int, len(baseline))
+	for _, fp := range baseline {
+		remaining[fp]++
+	}
+	var matched []int
+	for i, fp := range findings {
+		if fp != "" && remaining[fp] > 0 {
+			remaining[fp]--
+			matched = append(matched, i)
+		}
+	}
+	return matched
+}
+
+// applyDetektBaseline marks the detekt findings of a scan that are in its
+// project's baseline and records the new and suppressed counts. Scans of
+// projects without a baseline are left alone.
+func applyDetektBaseline(ctx context.Context, scanID string) error {
+	var projectID string
+	if err := dbPool.QueryRow(ctx, "SELECT project_id FROM scans WHERE id = $1", scanID).Scan(&projectID); err != nil {
+		return fmt.Errorf("failed to find project of scan %s: %w", scanID, err)
+	}
+	baseline, err := loadDetektBaseline(ctx, projectID)
+	if err != nil || baseline == nil {
+		return err
+	}
+	rows, err := dbPool.Query(ctx, `
+        SELECT id, COALESCE(fingerprint, '') FROM scan_findings WHERE scan_id = $1 AND analyzer = $2 ORDER BY id`,
+		scanID, detektAnalyzer{}.Name())
+	if err != nil {
+		return fmt.Errorf("failed to load detekt findings: %w", err)
+	}
+	var ids []int64
+	var fingerprints []string
+	for rows.Next() {
+		var id int64
+		var fp string
+		if err := rows.Scan(&id, &fp); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+		fingerprints = append(fingerprints, fp)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	var suppressed []int64
+	for _, i := range matchBaseline(baseline.fingerprints, fingerprints) {
+		suppressed = append(suppressed, ids[i])
+	}
+
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	if _, err := tx.Exec(ctx, "UPDATE scan_findings SET baseline_suppressed = TRUE WHERE id = ANY($1)", suppressed); err != nil {
+		return fmt.Errorf("failed to mark baseline findings: %w", err)
+	}
+	_, err = tx.Exec(ctx, `
+        UPDATE detekt_results SET new_issues = $1, baseline_suppressed_issues = $2 WHERE scan_id = $3`,
+		len(ids)-len(suppressed), len(suppressed), scanID)
+	if err != nil {
+		return fmt.Errorf("failed to record baseline counts: %w", err)
+	}
+	return tx.Commit(ctx)
+}
+
+// scanAnalyzerFindings returns the findings of one analyzer in a scan that
+// have a fingerprint.
+func scanAnalyzerFindings(ctx context.Context, scanID, analyzer string) ([]Finding, error) {
+	rows, err := dbPool.Query(ctx, `
+        SELECT rule_id, severity, COALESCE(category, ''), COALESCE(message, ''), COALESCE(file_path, ''), COALESCE(line, 0), fingerprint
+        FROM scan_findings
+        WHERE scan_id = $1 AND analyzer = $2 AND fingerprint IS NOT NULL ORDER BY id`,
+		scanID, analyzer)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	findings := []Finding{}
+	for rows.Next() {
+		f := Finding{Analyzer: analyzer}
+		if err := rows.Scan(&f.RuleID, &f.Severity, &f.Category, &f.Message, &f.File, &f.Line, &f.Fingerprint); err != nil {
+			return nil, err
+		}
+		findings = append(findings, f)
+	}
+	return findings, rows.Err()
+}
+
+func getDetektBaselineHandler(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	projectID := c.Param("id")
+	ctx := context.Background()
+
+	var exists bool
+	err := dbPool.QueryRow(ctx, "SELECT EXISTS(SELECT 1 FROM projects WHERE id=$1 AND user_id=$2)", projectID, userID.(string)).Scan(&exists)
+	if err != nil || !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+	baseline, err := loadDetektBaseline(ctx, projectID)
+	if err != nil {
+		log.Print(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch detekt baseline"})
+		return
+	}
+	if baseline == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "This project has no detekt baseline"})
+		return
+	}
+	c.JSON(http.StatusOK, baseline)
+}
+
+// createDetektBaselineHandler makes the detekt issues of one of the project's
+// scans its active baseline, replacing any earlier one. It applies from the
+// next scan on.
+func createDetektBaselineHandler(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	projectID := c.Param("id")
+
+	var req struct {
+		ScanID string `json:"scanId" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: scanId is required."})
+		return
+	}
+
+	ctx := context.Background()
+	var exists bool
+	err := dbPool.QueryRow(ctx, `
+        SELECT EXISTS(
+            SELECT 1 FROM detekt_results dr
+            INNER JOIN scans s ON dr.scan_id = s.id
+            WHERE s.id = $1 AND s.project_id = $2 AND s.user_id = $3)`,
+		req.ScanID, projectID, userID.(string)).Scan(&exists)
+	if err != nil || !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No detekt results found for this scan in this project"})
+		return
+	}
+	// The fingerprints stored with the findings are taken rather than
+	// parsing the report again, as its paths are the ones of the runner
+	// that produced it.
+	findings, err := scanAnalyzerFindings(ctx, req.ScanID, detektAnalyzer{}.Name())
+	if err != nil {
+		log.Printf("Failed to load detekt findings of scan %s: %v", req.ScanID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not save detekt baseline"})
+		return
+	}
+	fingerprints := make([]string, 0, len(findings))
+	for _, f := range findings {
+		fingerprints = append(fingerprints, f.Fingerprint)
+	}
+
+	// Not stored while the detekt config has a baseline file: issues
+	// that file hides never reach this baseline, and the two couldn't be
+	// told apart in the counts.
+	tag, err := dbPool.Exec(ctx, `
+        INSERT INTO detekt_baselines (project_id, source_scan_id, fingerprints, issues, issue_count)
+        SELECT $1::uuid, $2::uuid, $3::text[], $4::jsonb, $5::integer
+        WHERE NOT EXISTS (SELECT 1 FROM project_detekt_config WHERE project_id = $1 AND baseline_xml IS NOT NULL)
+        ON CONFLICT (project_id) DO UPDATE
+        SET source_scan_id = EXCLUDED.source_scan_id, fingerprints = EXCLUDED.fingerprints,
+            issues = EXCLUDED.issues, issue_count = EXCLUDED.issue_count, created_at = NOW()`,
+		projectID, req.ScanID, fingerprints, findings, len(fingerprints))
+	if err != nil {
+		log.Printf("Failed to store detekt baseline of project %s: %v", projectID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not save detekt baseline"})
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "The detekt config of this project has a baseline file; remove it before freezing issues from a scan"})
+		return
+	}
+	baseline, err := loadDetektBaseline(ctx, projectID)
+	if err != nil || baseline == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch detekt baseline"})
+		return
+	}
+	c.JSON(http.StatusCreated, baseline)
+}
+
+func deleteDetektBaselineHandler(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	projectID := c.Param("id")
+
+	tag, err := dbPool.Exec(context.Background(), `
+        DELETE FROM detekt_baselines b
+        USING projects p
+        WHERE b.project_id = p.id AND p.id = $1 AND p.user_id = $2`,
+		projectID, userID.(string))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not delete detekt baseline"})
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "This project has no detekt baseline"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Detekt baseline removed"})
+}
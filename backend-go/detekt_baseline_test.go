@@ -0,0 +1,60 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFindingFingerprint(t *testing.T) {
+	base := Finding{RuleID: "MagicNumber", File: "src/App.kt", Line: 10, Message: "This expression contains a magic number."}
+	tests := []struct {
+		name string
+		f    Finding
+		same bool
+	}{
+		{"identical", base, true},
+		{"other line", Finding{RuleID: base.RuleID, File: base.File, Line: 42, Message: base.Message}, true},
+		{"other severity", Finding{RuleID: base.RuleID, File: base.File, Line: base.Line, Message: base.Message, Severity: "major"}, true},
+		{"other rule", Finding{RuleID: "LongMethod", File: base.File, Line: base.Line, Message: base.Message}, false},
+		{"other file", Finding{RuleID: base.RuleID, File: "src/Other.kt", Line: base.Line, Message: base.Message}, false},
+		{"other message", Finding{RuleID: base.RuleID, File: base.File, Line: base.Line, Message: "Another message."}, false},
+		// The separator keeps fields from running into each other.
+		{"shifted fields", Finding{RuleID: "MagicNumbersrc", File: "/App.kt", Line: base.Line, Message: base.Message}, false},
+	}
+	want := findingFingerprint(base)
+	if len(want) != 40 {
+		t.Fatalf("fingerprint %q has length %d, want 40", want, len(want))
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := findingFingerprint(tt.f); (got == want) != tt.same {
+				t.Errorf("findingFingerprint(%+v) = %s, base is %s; want same = %v", tt.f, got, want, tt.same)
+			}
+		})
+	}
+}
+
+func TestMatchBaseline(t *testing.T) {
+	tests := []struct {
+		name     string
+		baseline []string
+		findings []string
+		want     []int
+	}{
+		{"no baseline", nil, []string{"a", "b"}, nil},
+		{"no findings", []string{"a"}, nil, nil},
+		{"all known", []string{"a", "b"}, []string{"b", "a"}, []int{0, 1}},
+		{"new issue", []string{"a"}, []string{"a", "c"}, []int{0}},
+		{"more occurrences than in the baseline", []string{"a"}, []string{"a", "a", "a"}, []int{0}},
+		{"repeated in the baseline", []string{"a", "a"}, []string{"a", "b", "a", "a"}, []int{0, 2}},
+		{"fewer occurrences than in the baseline", []string{"a", "a", "a"}, []string{"a"}, []int{0}},
+		{"no fingerprint", []string{""}, []string{"", "a"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matchBaseline(tt.baseline, tt.findings); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("matchBaseline(%q, %q) = %v, want %v", tt.baseline, tt.findings, got, tt.want)
+			}
+		})
+	}
+}
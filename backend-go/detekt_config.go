@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"gopkg.in/yaml.v3"
+)
+
+// Where the detekt configuration of a project comes from, stored in
+// project_detekt_config.mode and passed to analyze.sh as DETEKT_CONFIG_MODE.
+const (
+	detektConfigDefault    = "default"    // detekt's default rule set
+	detektConfigRepository = "repository" // the repository's own config file, if it has one
+	detektConfigCustom     = "custom"     // a config stored in the backend
+)
+
+// detektConfigMountPath is where the stored config and baseline of a project
+// are mounted in the analysis container, as config.yml and baseline.xml.
+const detektConfigMountPath = "/detekt-config"
+
+// detektConfigInfoFile is written by analyze.sh: the source of the config
+// detekt ran with and, for a repository config, its path. The config itself
+// is copied to detektConfigUsedFile.
+const (
+	detektConfigInfoFile = "detekt-config.txt"
+	detektConfigUsedFile = "detekt-config.yml"
+)
+
+// maxDetektConfigBytes caps the size of a stored config or baseline.
+const maxDetektConfigBytes = 1 << 20
+
+// projectDetektConfig is how detekt is configured for a project. Projects
+// without a stored configuration use the repository's config file.
+type projectDetektConfig struct {
+	Mode       string
+	ConfigPath string // repository mode only; empty looks in the usual places
+	Config     string // custom mode only
+	Baseline   string // detekt baseline XML, used in any mode
+}
+
+// detektConfigUsage records which configuration a scan ran detekt with, so
+// that results of scans with different configurations aren't compared
+// blindly. The hashes are SHA-256 of the config and baseline files.
+type detektConfigUsage struct {
+	Source       string  `json:"detekt_config_source"`
+	Path         *string `json:"detekt_config_path"`
+	ConfigHash   *string `json:"detekt_config_hash"`
+	BaselineHash *string `json:"detekt_baseline_hash"`
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func loadProjectDetektConfig(ctx context.Context, projectID string) (projectDetektConfig, error) {
+	cfg := projectDetektConfig{Mode: detektConfigRepository}
+	err := dbPool.QueryRow(ctx, `
+        SELECT mode, COALESCE(config_path, ''), COALESCE(config_yaml, ''), COALESCE(baseline_xml, '')
+        FROM project_detekt_config WHERE project_id = $1`, projectID,
+	).Scan(&cfg.Mode, &cfg.ConfigPath, &cfg.Config, &cfg.Baseline)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, fmt.Errorf("failed to load detekt config of project %s: %w", projectID, err)
+	}
+	return cfg, nil
+}
+
+// writeDetektConfigDir writes the stored config and baseline of a project to
+// a new directory for the analysis container. It returns "" when the project
+// has neither.
+func writeDetektConfigDir(cfg projectDetektConfig) (string, error) {
+	files := map[string]string{}
+	if cfg.Mode == detektConfigCustom && cfg.Config != "" {
+		files["config.yml"] = cfg.Config
+	}
+	if cfg.Baseline != "" {
+		files["baseline.xml"] = cfg.Baseline
+	}
+	if len(files) == 0 {
+		return "", nil
+	}
+	dir, err := os.MkdirTemp("", "scan-detekt-")
+	if err != nil {
+		return "", fmt.Errorf("failed to create detekt config dir: %w", err)
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			os.RemoveAll(dir)
+			return "", fmt.Errorf("failed to write detekt config: %w", err)
+		}
+	}
+	return dir, nil
+}
+
+func (cfg projectDetektConfig) containerEnv() []string {
+	return []string{
+		"DETEKT_CONFIG_MODE=" + cfg.Mode,
+		"DETEKT_CONFIG_PATH=" + cfg.ConfigPath,
+	}
+}
+
+// readDetektConfigUsage reads the config analyze.sh ran detekt with from
+// outputDir. The baseline is the project's stored one.
+func readDetektConfigUsage(outputDir string, cfg projectDetektConfig) (detektConfigUsage, error) {
+	f, err := os.Open(filepath.Join(outputDir, detektConfigInfoFile))
+	if err != nil {
+		return detektConfigUsage{}, err
+	}
+	defer f.Close()
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, strings.TrimSpace(scanner.Text()))
+	}
+	if len(lines) == 0 || lines[0] == "" {
+		return detektConfigUsage{}, errors.New("incomplete detekt config info")
+	}
+
+	usage := detektConfigUsage{Source: lines[0]}
+	if len(lines) > 1 {
+		usage.Path = nullIfEmpty(lines[1])
+	}
+	if usage.Source != detektConfigDefault {
+		usedPath := filepath.Join(outputDir, detektConfigUsedFile)
+		if info, err := os.Stat(usedPath); err == nil && info.Size() <= maxDetektConfigBytes {
+			if config, err := os.ReadFile(usedPath); err == nil {
+				hash := sha256Hex(config)
+				usage.ConfigHash = &hash
+			}
+		}
+	}
+	if cfg.Baseline != "" {
+		hash := sha256Hex([]byte(cfg.Baseline))
+		usage.BaselineHash = &hash
+	}
+	return usage, nil
+}
+
+func recordDetektConfigUsage(ctx context.Context, scanID string, usage detektConfigUsage) error {
+	_, err := dbPool.Exec(ctx, `
+        UPDATE scans SET detekt_config_source = $1, detekt_config_path = $2, detekt_config_hash = $3, detekt_baseline_hash = $4
+        WHERE id = $5`,
+		usage.Source, usage.Path, usage.ConfigHash, usage.BaselineHash, scanID)
+	if err != nil {
+		return fmt.Errorf("failed to record detekt config of scan %s: %w", scanID, err)
+	}
+	return nil
+}
+
+func getProjectDetektConfigHandler(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	projectID := c.Param("id")
+	ctx := context.Background()
+
+	var exists bool
+	err := dbPool.QueryRow(ctx, "SELECT EXISTS(SELECT 1 FROM projects WHERE id=$1 AND user_id=$2)", projectID, userID.(string)).Scan(&exists)
+	if err != nil || !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+	cfg, err := loadProjectDetektConfig(ctx, projectID)
+	if err != nil {
+		log.Print(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch detekt config"})
+		return
+	}
+	resp := gin.H{
+		"mode":         cfg.Mode,
+		"configPath":   nullIfEmpty(cfg.ConfigPath),
+		"config":       nullIfEmpty(cfg.Config),
+		"baseline":     nullIfEmpty(cfg.Baseline),
+		"configHash":   nil,
+		"baselineHash": nil,
+	}
+	if cfg.Config != "" {
+		resp["configHash"] = sha256Hex([]byte(cfg.Config))
+	}
+	if cfg.Baseline != "" {
+		resp["baselineHash"] = sha256Hex([]byte(cfg.Baseline))
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// updateProjectDetektConfigHandler sets how detekt is configured for a
+// project: "default", "repository" (optionally with the path of the config
+// file in the repository) or "custom" with the config YAML. A detekt
+// baseline can be stored in any mode. The settings apply from the next scan.
+func updateProjectDetektConfigHandler(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	projectID := c.Param("id")
+
+	var req struct {
+		Mode       string `json:"mode" binding:"required"`
+		ConfigPath string `json:"configPath"`
+		Config     string `json:"config"`
+		Baseline   string `json:"baseline"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data."})
+		return
+	}
+	if len(req.Config) > maxDetektConfigBytes || len(req.Baseline) > maxDetektConfigBytes {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "config and baseline must be at most 1 MiB each"})
+		return
+	}
+
+	cfg := projectDetektConfig{Mode: req.Mode, Baseline: strings.TrimSpace(req.Baseline)}
+	switch req.Mode {
+	case detektConfigDefault:
+	case detektConfigRepository:
+		if p := strings.TrimSpace(req.ConfigPath); p != "" {
+			p = path.Clean(p)
+			if !sourcePathPattern.MatchString(p) || strings.HasPrefix(p, "/") || p == ".." || strings.HasPrefix(p, "../") {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "configPath must be a path relative to the repository root"})
+				return
+			}
+			cfg.ConfigPath = p
+		}
+	case detektConfigCustom:
+		var doc map[string]interface{}
+		if strings.TrimSpace(req.Config) == "" || yaml.Unmarshal([]byte(req.Config), &doc) != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "config must be a detekt YAML configuration"})
+			return
+		}
+		cfg.Config = req.Config
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "mode must be \"default\", \"repository\" or \"custom\""})
+		return
+	}
+	if cfg.Baseline != "" {
+		var baseline struct {
+			XMLName xml.Name `xml:"SmellBaseline"`
+		}
+		if err := xml.Unmarshal([]byte(cfg.Baseline), &baseline); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "baseline must be a detekt baseline XML file"})
+			return
+		}
+	}
+
+	ctx := context.Background()
+	var exists bool
+	err := dbPool.QueryRow(ctx, "SELECT EXISTS(SELECT 1 FROM projects WHERE id=$1 AND user_id=$2)", projectID, userID.(string)).Scan(&exists)
+	if err != nil || !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+	// A baseline file is refused while the project has a baseline frozen
+	// from a scan (see createDetektBaselineHandler); only one of them can
+	// be in use.
+	tag, err := dbPool.Exec(ctx, `
+        INSERT INTO project_detekt_config (project_id, mode, config_path, config_yaml, baseline_xml, updated_at)
+        SELECT $1::uuid, $2::text, NULLIF($3::text, ''), NULLIF($4::text, ''), NULLIF($5::text, ''), NOW()
+        WHERE $5::text = '' OR NOT EXISTS (SELECT 1 FROM detekt_baselines WHERE project_id = $1)
+        ON CONFLICT (project_id) DO UPDATE
+        SET mode = EXCLUDED.mode, config_path = EXCLUDED.config_path, config_yaml = EXCLUDED.config_yaml,
+            baseline_xml = EXCLUDED.baseline_xml, updated_at = NOW()`,
+		projectID, cfg.Mode, cfg.ConfigPath, cfg.Config, cfg.Baseline)
+	if err != nil {
+		log.Printf("Failed to store detekt config of project %s: %v", projectID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not save detekt config"})
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "This project has a detekt baseline made from a scan; delete it before setting a baseline file"})
+		return
+	}
+	getProjectDetektConfigHandler(c)
+}
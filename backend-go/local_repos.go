@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// localRepoMountPath is where a local repository is mounted, read-only, in the
+// analysis container. analyze.sh clones from there.
+const localRepoMountPath = "/mirror"
+
+var errLocalReposDisabled = errors.New("local repositories are not enabled on this server")
+
+// isLocalRepoURL tells whether a repository URL refers to the filesystem of
+// the server: a file:// URL or an absolute path.
+func isLocalRepoURL(url string) bool {
+	return strings.HasPrefix(url, "file://") || strings.HasPrefix(url, "/")
+}
+
+// localRepoRoots returns the directories local repositories may be scanned
+// from, as set by the administrator in LOCAL_REPO_ROOTS. Roots that don't
+// exist are left out.
+func localRepoRoots() []string {
+	var roots []string
+	for _, root := range getEnvList("LOCAL_REPO_ROOTS") {
+		resolved, err := filepath.EvalSymlinks(filepath.Clean(root))
+		if err != nil || !filepath.IsAbs(resolved) {
+			continue
+		}
+		roots = append(roots, resolved)
+	}
+	return roots
+}
+
+// resolveLocalRepo turns a file:// URL or absolute path into the path of a
+// git repository inside one of the allowed roots. Symlinks are resolved first
+// so they can't lead out of a root.
+func resolveLocalRepo(url string) (string, error) {
+	roots := localRepoRoots()
+	if len(roots) == 0 {
+		return "", errLocalReposDisabled
+	}
+	path := url
+	if rest, ok := strings.CutPrefix(url, "file://"); ok {
+		// Only file:///path is accepted; file://host/path names another host.
+		if !strings.HasPrefix(rest, "/") {
+			return "", errors.New("file:// URLs must have an absolute path and no host")
+		}
+		path = rest
+	}
+	resolved, err := filepath.EvalSymlinks(filepath.Clean(path))
+	if err != nil {
+		return "", fmt.Errorf("repository %s not found", url)
+	}
+
+	allowed := false
+	for _, root := range roots {
+		if rel, err := filepath.Rel(root, resolved); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return "", fmt.Errorf("repository %s is outside of the allowed directories", url)
+	}
+	if !isGitRepoDir(resolved) {
+		return "", fmt.Errorf("%s is not a git repository", url)
+	}
+	return resolved, nil
+}
+
+// isGitRepoDir tells whether dir is a bare repository or a working tree with
+// a .git directory.
+func isGitRepoDir(dir string) bool {
+	for _, gitDir := range []string{dir, filepath.Join(dir, ".git")} {
+		head, err := os.Stat(filepath.Join(gitDir, "HEAD"))
+		if err != nil || !head.Mode().IsRegular() {
+			continue
+		}
+		if objects, err := os.Stat(filepath.Join(gitDir, "objects")); err == nil && objects.IsDir() {
+			return true
+		}
+	}
+	return false
+}
+
+// projectNameFromURL derives a project name from a repository URL or path:
+// its last element without a ".git" suffix. It handles https URLs, scp-style
+// SSH URLs (git@host:owner/repo.git), file:// URLs and filesystem paths.
+func projectNameFromURL(url string) string {
+	name := strings.TrimRight(url, "/")
+	name = strings.TrimRight(strings.TrimSuffix(name, ".git"), "/")
+	if i := strings.LastIndexAny(name, "/:"); i >= 0 {
+		name = name[i+1:]
+	}
+	if name == "" {
+		return url
+	}
+	return name
+}
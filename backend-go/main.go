@@ -10,16 +10,12 @@ import (
 	"log"
 	"net/http"
 	"os"
-	"path/filepath"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
 	"time"
 
-	"github.com/docker/docker/api/types/container"
-	"github.com/docker/docker/api/types/mount"
-	"github.com/docker/docker/client"
-	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-contrib/sessions/cookie"
@@ -38,6 +34,10 @@ type SonarQubeIssuesResponse struct {
 	Issues []struct {
 		Rule      string `json:"rule"`
 		Component string `json:"component"`
+		Severity  string `json:"severity"`
+		Type      string `json:"type"`
+		Message   string `json:"message"`
+		Line      int    `json:"line"`
 	} `json:"issues"`
 }
 type SonarQubeMeasuresResponse struct {
@@ -64,22 +64,42 @@ type DetektReport struct {
 type Error struct {
 	Severity string `xml:"severity,attr"`
 	Source   string `xml:"source,attr"`
+	Message  string `xml:"message,attr"`
+	Line     int    `xml:"line,attr"`
 }
 type DetektCounts struct {
 	ErrorIssues, WarningIssues, InfoIssues int
 }
 
 type TrendData struct {
-	ScanID                string    `json:"scan_id"`
-	DetectedAt            time.Time `json:"detected_at"`
-	MaintainabilityRating *int      `json:"maintainability_rating"`
-	CognitiveComplexity   *int      `json:"cognitive_complexity"`
-	LinesOfCode           *int      `json:"lines_of_code"`
-	TotalDetektIssues     int       `json:"total_detekt_issues"`
-	TotalSonarIssues      int       `json:"total_sonar_issues"`
-	BlockerIssues         int       `json:"blocker_issues"`
-	CriticalIssues        int       `json:"critical_issues"`
-	MajorIssues           int       `json:"major_issues"`
+	ScanID     string    `json:"scan_id"`
+	DetectedAt time.Time `json:"detected_at"`
+	Status     string    `json:"status"`
+	Ref        *string   `json:"ref"` // requested branch, tag or commit; null for the default branch
+	ScanProvenance
+	scanToolchain
+	// ToolchainChanges lists what changed in the toolchain since the
+	// previous point, so that a jump in the trend can be told apart from a
+	// change in the code.
+	ToolchainChanged       bool     `json:"toolchain_changed"`
+	ToolchainChanges       []string `json:"toolchain_changes"`
+	MaintainabilityRating  *int     `json:"maintainability_rating"`
+	CognitiveComplexity    *int     `json:"cognitive_complexity"`
+	LinesOfCode            *int     `json:"lines_of_code"`
+	TotalDetektIssues      int      `json:"total_detekt_issues"`
+	TotalSonarIssues       int      `json:"total_sonar_issues"`
+	TotalKtlintIssues      int      `json:"total_ktlint_issues"`
+	TotalAndroidLintIssues int      `json:"total_android_lint_issues"`
+	// DetektNewIssues and DetektBaselineIssues split the detekt issues of
+	// scans run while the project had a baseline; both are null otherwise.
+	DetektNewIssues      *int `json:"detekt_new_issues"`
+	DetektBaselineIssues *int `json:"detekt_baseline_issues"`
+	BlockerIssues        int  `json:"blocker_issues"`
+	CriticalIssues       int  `json:"critical_issues"`
+	MajorIssues          int  `json:"major_issues"`
+	// IssuesByAnalyzer holds the number of findings of every analyzer that
+	// produced a report for the scan.
+	IssuesByAnalyzer map[string]int `json:"issues_by_analyzer"`
 }
 type RuleBreakdown struct {
 	RuleName   string `json:"rule_name"`
@@ -100,13 +120,29 @@ type LatestDetektDistribution struct {
 	Warnings int `json:"warnings"`
 	Infos    int `json:"infos"`
 }
+
+// LatestScanStatus describes the most recent scan of a project, whatever its
+// outcome, so clients can tell a failed scan apart from a clean one.
+type LatestScanStatus struct {
+	ScanID         string        `json:"scan_id"`
+	Status         string        `json:"status"`
+	Ref            *string       `json:"ref"`
+	StartedAt      time.Time     `json:"started_at"`
+	FinishedAt     *time.Time    `json:"finished_at"`
+	FailureReasons []scanFailure `json:"failure_reasons"`
+}
+
 type AnalyticsResponse struct {
-	TrendData                []TrendData              `json:"trend_data"`
-	LatestScanData           LatestScanDistribution   `json:"latest_scan_data"`
-	LatestDetektDistribution LatestDetektDistribution `json:"latest_detekt_distribution"`
-	LatestSonarRules         []RuleBreakdown          `json:"latest_sonar_rules"`
-	LatestDetektRules        []RuleBreakdown          `json:"latest_detekt_rules"`
-	LatestNoisyFiles         []FileBreakdown          `json:"latest_noisy_files"`
+	LatestScan               *LatestScanStatus          `json:"latest_scan"`
+	TrendData                []TrendData                `json:"trend_data"`
+	LatestScanData           LatestScanDistribution     `json:"latest_scan_data"`
+	LatestDetektDistribution LatestDetektDistribution   `json:"latest_detekt_distribution"`
+	LatestSonarRules         []RuleBreakdown            `json:"latest_sonar_rules"`
+	LatestDetektRules        []RuleBreakdown            `json:"latest_detekt_rules"`
+	LatestKtlintRules        []RuleBreakdown            `json:"latest_ktlint_rules"`
+	LatestAndroidLintRules   []RuleBreakdown            `json:"latest_android_lint_rules"`
+	LatestNoisyFiles         []FileBreakdown            `json:"latest_noisy_files"`
+	LatestRulesByAnalyzer    map[string][]RuleBreakdown `json:"latest_rules_by_analyzer"`
 }
 
 func main() {
@@ -132,6 +168,14 @@ func main() {
 	}
 	fmt.Println("Connected to the database!")
 
+	scanRunner, err = newAnalysisRunner(os.Getenv("SCAN_RUNNER"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	startScanWorkers(loadScanQueueConfig())
+	startScanScheduler(getEnvDuration("SCAN_SCHEDULER_INTERVAL", 30*time.Second))
+	go redactStoredScanLogs(context.Background())
+
 	r := gin.Default()
 
 	sessionSecret := os.Getenv("SESSION_SECRET")
@@ -158,17 +202,44 @@ func main() {
 	r.POST("/api/register", registerHandler)
 	r.POST("/api/login", loginHandler)
 	r.POST("/api/logout", logoutHandler)
+	r.POST("/api/webhooks/push", pushWebhookHandler)
 
 	protected := r.Group("/")
 	protected.Use(authMiddleware())
 	{
 		protected.GET("/api/portfolio", portfolioHandler)
 		protected.POST("/api/scan", runScanHandler)
+		protected.POST("/api/scan/upload", uploadScanHandler)
 		protected.GET("/api/projects", listProjectsHandler)
+		protected.POST("/api/projects", createProjectHandler)
 		protected.GET("/api/project/:projectId/scans", listProjectScansHandler)
+		protected.GET("/api/scan/:scanId/status", scanStatusHandler)
+		protected.POST("/api/scan/:scanId/cancel", cancelScanHandler)
+		protected.GET("/api/scan/:scanId/logs", getScanLogHandler)
+		protected.GET("/api/scan/:scanId/logs/stream", streamScanLogsHandler)
 		protected.GET("/api/scan/:scanId/detekt", getDetektResultByScanHandler)
 		protected.GET("/api/scan/:scanId/sonarqube", getSonarQubeIssuesByScanHandler)
+		protected.GET("/api/scan/:scanId/findings", getScanFindingsHandler)
+		protected.POST("/api/scan/:scanId/reports/:analyzer", uploadScanReportHandler)
 		protected.GET("/api/projects/:id/analytics", getProjectAnalyticsHandler)
+		protected.GET("/api/projects/:id/limits", getProjectLimitsHandler)
+		protected.PUT("/api/projects/:id/limits", updateProjectLimitsHandler)
+		protected.GET("/api/projects/:id/sources", getProjectSourcesHandler)
+		protected.PUT("/api/projects/:id/sources", updateProjectSourcesHandler)
+		protected.GET("/api/projects/:id/detekt-config", getProjectDetektConfigHandler)
+		protected.PUT("/api/projects/:id/detekt-config", updateProjectDetektConfigHandler)
+		protected.GET("/api/projects/:id/detekt-baseline", getDetektBaselineHandler)
+		protected.POST("/api/projects/:id/detekt-baseline", createDetektBaselineHandler)
+		protected.DELETE("/api/projects/:id/detekt-baseline", deleteDetektBaselineHandler)
+		protected.GET("/api/projects/:id/schedule", getScanScheduleHandler)
+		protected.PUT("/api/projects/:id/schedule", putScanScheduleHandler)
+		protected.DELETE("/api/projects/:id/schedule", deleteScanScheduleHandler)
+		protected.GET("/api/projects/:id/credentials", getProjectCredentialsHandler)
+		protected.PUT("/api/projects/:id/credentials", putProjectCredentialsHandler)
+		protected.DELETE("/api/projects/:id/credentials", deleteProjectCredentialsHandler)
+		protected.GET("/api/projects/:id/webhook", getProjectWebhookHandler)
+		protected.PUT("/api/projects/:id/webhook", putProjectWebhookHandler)
+		protected.DELETE("/api/projects/:id/webhook", deleteProjectWebhookHandler)
 	}
 
 	port := os.Getenv("PORT")
@@ -356,206 +427,187 @@ func portfolioHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"id": userID, "username": username})
 }
 
-func createScan(ctx context.Context, projectID, userID string) (string, error) {
+// createScan records a new scan of a project. An empty ref scans the
+// default branch; a commit, if given, is checked out after the ref so that
+// the scan analyzes exactly that commit of the branch. Unless force is set,
+// the scan reuses the results of an earlier one of the same commit and
+// configuration instead of running the analysis.
+func createScan(ctx context.Context, projectID, userID, ref, commit string, force bool) (string, error) {
 	var scanID string
 	err := dbPool.QueryRow(ctx, `
-        INSERT INTO scans (project_id, user_id, started_at) VALUES ($1, $2, NOW()) RETURNING id
-    `, projectID, userID).Scan(&scanID)
+        INSERT INTO scans (project_id, user_id, started_at, requested_ref, requested_commit, force_rerun)
+        VALUES ($1, $2, NOW(), NULLIF($3, ''), NULLIF($4, ''), $5) RETURNING id
+    `, projectID, userID, ref, commit, force).Scan(&scanID)
 	return scanID, err
 }
 
-func runScanHandler(c *gin.Context) {
-	var req struct {
-		RepoURL string `json:"repoUrl" binding:"required"`
+// queueScan creates a scan of a project and puts it in the scan queue.
+// force makes the scan run the analysis even if an earlier scan's results
+// could be reused. attach, if not nil, stores what the scan needs besides its
+// job, such as uploaded sources, before it is queued. The scan is removed
+// again if it can't be queued.
+func queueScan(ctx context.Context, projectID, userID, repoURL, ref, commit string, force bool, attach func(scanID string) error) (string, error) {
+	scanID, err := createScan(ctx, projectID, userID, ref, commit, force)
+	if err != nil {
+		return "", fmt.Errorf("failed to create scan entry: %w", err)
 	}
-	if err := c.ShouldBindJSON(&req); err != nil || req.RepoURL == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Invalid request: repoUrl is required."})
-		return
+	if attach != nil {
+		err = attach(scanID)
+	}
+	if err == nil {
+		err = enqueueScan(ctx, scanJob{
+			ScanID:          scanID,
+			ProjectID:       projectID,
+			UserID:          userID,
+			RepoURL:         repoURL,
+			Ref:             ref,
+			Commit:          commit,
+			Force:           force,
+			SonarProjectKey: fmt.Sprintf("proj_%s_%s", userID, projectID),
+		})
 	}
-
-	userID, _ := c.Get("userID")
-
-	ctx := context.Background()
-	var projectID string
-	parts := strings.Split(strings.TrimSuffix(req.RepoURL, ".git"), "/")
-	projectName := parts[len(parts)-1]
-
-	err := dbPool.QueryRow(ctx, "SELECT id FROM projects WHERE user_id = $1 AND url = $2", userID.(string), req.RepoURL).Scan(&projectID)
 	if err != nil {
-		err2 := dbPool.QueryRow(ctx,
-			`INSERT INTO projects (user_id, name, url) VALUES ($1, $2, $3) RETURNING id`,
-			userID.(string), projectName, req.RepoURL).Scan(&projectID)
-		if err2 != nil {
-			log.Printf("Error inserting new project %s: %v", projectName, err2)
-			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Could not save project"})
-			return
+		if _, delErr := dbPool.Exec(ctx, "DELETE FROM scans WHERE id = $1", scanID); delErr != nil {
+			log.Printf("Failed to remove unqueued scan %s: %v", scanID, delErr)
 		}
+		return "", err
 	}
+	return scanID, nil
+}
 
-	scanID, err := createScan(ctx, projectID, userID.(string))
-	if err != nil {
-		log.Printf("Failed to create scan entry: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Could not create scan"})
-		return
+// findOrCreateProject returns the user's project covering the whole
+// repository at url, creating it under name if there is none yet. Projects
+// restricted to source paths are only scanned by ID.
+func findOrCreateProject(ctx context.Context, userID, name, url string) (string, error) {
+	var projectID string
+	err := dbPool.QueryRow(ctx, "SELECT id FROM projects WHERE user_id = $1 AND url = $2 AND source_paths = '{}'", userID, url).Scan(&projectID)
+	if err == nil {
+		return projectID, nil
 	}
+	if projectID, err = findUnnormalizedProject(ctx, userID, url); err != nil || projectID != "" {
+		return projectID, err
+	}
+	err = dbPool.QueryRow(ctx,
+		`INSERT INTO projects (user_id, name, url) VALUES ($1, $2, $3) RETURNING id`,
+		userID, name, url).Scan(&projectID)
+	return projectID, err
+}
 
-	projectKeyForSonar := fmt.Sprintf("proj_%s_%s", userID.(string), projectID)
-
-	detektXML, sonarIssuesJSON, sonarMeasuresJSON, err := runAnalysisContainerAndFetchResults(req.RepoURL, projectKeyForSonar, scanID)
+// findUnnormalizedProject looks for a project of the user stored before
+// repository URLs were normalized whose URL normalizes to url, and stores
+// the normalized URL for it. It returns "" if there is none.
+func findUnnormalizedProject(ctx context.Context, userID, url string) (string, error) {
+	if isUploadURL(url) || isLocalRepoURL(url) {
+		return "", nil
+	}
+	rows, err := dbPool.Query(ctx, `
+        SELECT id, url FROM projects WHERE user_id = $1 AND source_paths = '{}' ORDER BY submitted_at`, userID)
 	if err != nil {
-		log.Printf("Scan failed for %s: %v", req.RepoURL, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Analysis failed", "details": err.Error()})
-		return
+		return "", err
 	}
-
-	if detektXML != "" {
-		detektCounts, detektParseErr := parseDetektReport(detektXML)
-		if detektParseErr != nil {
-			log.Printf("Warning: Failed to parse Detekt XML for scan %s: %v", scanID, detektParseErr)
-		} else {
-			_, err = dbPool.Exec(ctx, `
-                INSERT INTO detekt_results (scan_id, detekt_xml, error_issues, warning_issues, info_issues)
-                VALUES ($1, $2, $3, $4, $5)`,
-				scanID, detektXML, detektCounts.ErrorIssues, detektCounts.WarningIssues, detektCounts.InfoIssues,
-			)
-			if err != nil {
-				log.Printf("Failed to insert detekt result for scanID %s: %v", scanID, err)
-			}
+	var projectID string
+	for rows.Next() {
+		var id, stored string
+		if err := rows.Scan(&id, &stored); err != nil {
+			rows.Close()
+			return "", err
 		}
-	}
-
-	if sonarMeasuresJSON != "" && sonarIssuesJSON != "" {
-		sonarMetrics, sonarParseErr := parseSonarQubeMeasures(sonarMeasuresJSON)
-		if sonarParseErr != nil {
-			log.Printf("Warning: Failed to parse SonarQube measures for scan %s: %v", scanID, sonarParseErr)
-		} else {
-			_, err = dbPool.Exec(ctx, `
-                INSERT INTO sonarqube_results (scan_id, sonar_json, blocker_issues, critical_issues, major_issues, minor_issues, info_issues, code_smells, bugs, vulnerabilities)
-                VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
-				scanID, sonarIssuesJSON, sonarMetrics.BlockerIssues, sonarMetrics.CriticalIssues,
-				sonarMetrics.MajorIssues, sonarMetrics.MinorIssues, sonarMetrics.InfoIssues, sonarMetrics.CodeSmells,
-				sonarMetrics.Bugs, sonarMetrics.Vulnerabilities,
-			)
-			if err != nil {
-				log.Printf("Failed to insert sonarqube_results for scanID %s: %v", scanID, err)
-			}
-			_, err = dbPool.Exec(ctx, `
-                UPDATE scans SET lines_of_code = $1, maintainability_rating = $2, cognitive_complexity = $3
-                WHERE id = $4`,
-				sonarMetrics.LinesOfCode, sonarMetrics.MaintainabilityRating, sonarMetrics.CognitiveComplexity, scanID,
-			)
-			if err != nil {
-				log.Printf("Failed to update scans table for scanID %s: %v", scanID, err)
-			}
+		if u, err := parseRepoURL(stored); err == nil && u.String() == url {
+			projectID = id
+			break
 		}
 	}
+	rows.Close()
+	if err := rows.Err(); err != nil || projectID == "" {
+		return "", err
+	}
+	if _, err := dbPool.Exec(ctx, "UPDATE projects SET url = $1 WHERE id = $2", url, projectID); err != nil {
+		log.Printf("Failed to normalize the URL of project %s: %v", projectID, err)
+	}
+	return projectID, nil
+}
+
+var gitRefPattern = regexp.MustCompile(`^[A-Za-z0-9._/-]+$`)
 
-	c.JSON(http.StatusOK, gin.H{"scanId": scanID})
+// validGitRef accepts branch names, tags and commit SHAs. Anything that could
+// be mistaken for an option or a revision expression is rejected.
+func validGitRef(ref string) bool {
+	return len(ref) <= 255 && gitRefPattern.MatchString(ref) &&
+		!strings.HasPrefix(ref, "-") && !strings.HasPrefix(ref, "/") &&
+		!strings.Contains(ref, "..") && !strings.HasSuffix(ref, "/") && !strings.HasSuffix(ref, ".lock")
 }
 
-func runAnalysisContainerAndFetchResults(repoURL, sonarProjectKey, scanID string) (string, string, string, error) {
-	tempDir, err := os.MkdirTemp("", "scan-")
-	if err != nil {
-		return "", "", "", fmt.Errorf("failed to create temp dir: %w", err)
+func runScanHandler(c *gin.Context) {
+	var req struct {
+		RepoURL   string `json:"repoUrl"`
+		ProjectID string `json:"projectId"` // scans an existing project instead, e.g. one module of a monorepo
+		Ref       string `json:"ref"`       // optional branch, tag or commit SHA
+		Force     bool   `json:"force"`     // run the analysis even if the commit was analyzed before
 	}
-	defer os.RemoveAll(tempDir)
-
-	ctx := context.Background()
-	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
-	if err != nil {
-		return "", "", "", fmt.Errorf("docker client error: %w", err)
+	if err := c.ShouldBindJSON(&req); err != nil || (req.RepoURL == "" && req.ProjectID == "") {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Invalid request: repoUrl or projectId is required."})
+		return
 	}
-	defer cli.Close()
-
-	sonarScannerHostURL := os.Getenv("SONAR_SCANNER_HOST_URL")
-
-	if sonarScannerHostURL == "" {
-		sonarScannerHostURL = "http://host.docker.internal:9000"
+	req.Ref = strings.TrimSpace(req.Ref)
+	if req.Ref != "" && !validGitRef(req.Ref) {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Invalid request: ref must be a branch, tag or commit SHA."})
+		return
 	}
 
-	sonarToken := os.Getenv("SONAR_LOGIN_TOKEN")
-
-	log.Printf("Starting analysis container for project key: %s, version: %s", sonarProjectKey, scanID)
+	userID, _ := c.Get("userID")
+	ctx := context.Background()
 
-	resp, err := cli.ContainerCreate(ctx, &container.Config{
-		Image: "repo-analyzer:latest",
-		Env: []string{
-			fmt.Sprintf("REPO_URL=%s", repoURL),
-			fmt.Sprintf("SONAR_PROJECT_KEY=%s", sonarProjectKey),
-			fmt.Sprintf("SONAR_ANALYSIS_VERSION=%s", scanID),
-			fmt.Sprintf("SONAR_HOST_URL=%s", sonarScannerHostURL),
-			fmt.Sprintf("SONAR_TOKEN=%s", sonarToken),
-		},
-		Tty: false,
-	}, &container.HostConfig{
-		Mounts:     []mount.Mount{{Type: mount.TypeBind, Source: tempDir, Target: "/data"}},
-		AutoRemove: true,
-	}, nil, nil, "")
-	if err != nil {
-		return "", "", "", fmt.Errorf("failed to create container: %w", err)
+	var projectID string
+	if req.ProjectID != "" {
+		err := dbPool.QueryRow(ctx, "SELECT url FROM projects WHERE id = $1 AND user_id = $2", req.ProjectID, userID.(string)).Scan(&req.RepoURL)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "error": "Project not found"})
+			return
+		}
+		projectID = req.ProjectID
 	}
 
-	if err := cli.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
-		return "", "", "", fmt.Errorf("failed to start container: %w", err)
+	if isUploadURL(req.RepoURL) {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Invalid request: uploaded sources are scanned with POST /api/scan/upload."})
+		return
 	}
 
-	logReader, err := cli.ContainerLogs(ctx, resp.ID, container.LogsOptions{ShowStdout: true, ShowStderr: true, Follow: true})
-	if err != nil {
-		log.Printf("Error getting container logs: %v", err)
+	if isLocalRepoURL(req.RepoURL) {
+		if _, err := resolveLocalRepo(req.RepoURL); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Invalid request: " + err.Error()})
+			return
+		}
 	} else {
-		defer logReader.Close()
-		go func() {
-			stdcopy.StdCopy(os.Stdout, os.Stderr, logReader)
-		}()
+		normalized, err := validateRepoURL(ctx, req.RepoURL)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Invalid request: " + err.Error()})
+			return
+		}
+		req.RepoURL = normalized
 	}
 
-	log.Printf("Analysis container %s started. Waiting for completion...", resp.ID[:12])
-	statusCh, errCh := cli.ContainerWait(ctx, resp.ID, container.WaitConditionNotRunning)
-	select {
-	case err := <-errCh:
+	if projectID == "" {
+		projectName := projectNameFromURL(req.RepoURL)
+		var err error
+		projectID, err = findOrCreateProject(ctx, userID.(string), projectName, req.RepoURL)
 		if err != nil {
-			return "", "", "", fmt.Errorf("container execution error: %w", err)
-		}
-	case status := <-statusCh:
-		if status.StatusCode != 0 {
-			return "", "", "", fmt.Errorf("analysis container exited with non-zero status: %d", status.StatusCode)
+			log.Printf("Error inserting new project %s: %v", projectName, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Could not save project"})
+			return
 		}
-		log.Printf("Container %s finished successfully.", resp.ID[:12])
 	}
 
-	reportPath := filepath.Join(tempDir, "detekt-report.xml")
-	detektBytes, err := os.ReadFile(reportPath)
+	scanID, err := queueScan(ctx, projectID, userID.(string), req.RepoURL, req.Ref, "", req.Force, nil)
 	if err != nil {
-		log.Printf("Warning: Could not read Detekt report file: %v", err)
-	}
-
-	sonarHostURL := "http://localhost:9000"
-	apiToken := os.Getenv("SONAR_API_TOKEN")
-	if apiToken == "" {
-		log.Println("Warning: SONAR_API_TOKEN is not set. Falling back to SONAR_API_TOKEN. This may cause permission issues.")
-		apiToken = sonarToken
-	}
-
-	log.Printf("Waiting for SonarQube to process analysis for %s...", sonarProjectKey)
-	if err := waitForSonarQubeAnalysis(sonarProjectKey, scanID, sonarHostURL, apiToken, 300*time.Second); err != nil {
-		log.Printf("Warning: %v", err)
-	}
-
-	log.Printf("Fetching SonarQube issues and measures for %s...", sonarProjectKey)
-	sonarIssuesJSON, issuesErr := fetchSonarQubeAPI(sonarProjectKey, sonarHostURL, apiToken, "api/issues/search")
-	sonarMeasuresJSON, measuresErr := fetchSonarQubeAPI(sonarProjectKey, sonarHostURL, apiToken, "api/measures/component")
-
-	if issuesErr != nil {
-		log.Printf("Warning: Could not fetch SonarQube issues: %v", issuesErr)
-	}
-	if measuresErr != nil {
-		log.Printf("Warning: Could not fetch SonarQube measures: %v", measuresErr)
+		log.Printf("Failed to queue scan: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Could not queue scan"})
+		return
 	}
 
-	return string(detektBytes), sonarIssuesJSON, sonarMeasuresJSON, nil
+	c.JSON(http.StatusAccepted, gin.H{"scanId": scanID, "status": scanStatusQueued, "ref": nullIfEmpty(req.Ref)})
 }
 
-func fetchSonarQubeAPI(projectKey, sonarHostURL, sonarToken, endpoint string) (string, error) {
+func fetchSonarQubeAPI(ctx context.Context, projectKey, sonarHostURL, sonarToken, endpoint string) (string, error) {
 	sonarHostURL = strings.TrimSuffix(sonarHostURL, "/")
 
 	var apiURL string
@@ -568,7 +620,7 @@ func fetchSonarQubeAPI(projectKey, sonarHostURL, sonarToken, endpoint string) (s
 	}
 
 	httpClient := &http.Client{Timeout: 45 * time.Second}
-	req, err := http.NewRequest("GET", apiURL, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
 	if err != nil {
 		return "", fmt.Errorf("failed to create SonarQube API request: %w", err)
 	}
@@ -596,7 +648,7 @@ func fetchSonarQubeAPI(projectKey, sonarHostURL, sonarToken, endpoint string) (s
 	return string(bodyBytes), nil
 }
 
-func waitForSonarQubeAnalysis(projectKey, scanID, sonarHostURL, sonarToken string, timeout time.Duration) error {
+func waitForSonarQubeAnalysis(ctx context.Context, projectKey, scanID, sonarHostURL, sonarToken string, timeout time.Duration) error {
 	sonarHostURL = strings.TrimSuffix(sonarHostURL, "/")
 	// Fetch the latest analysis for the project
 	apiURL := fmt.Sprintf("%s/api/project_analyses/search?project=%s&ps=1", sonarHostURL, projectKey)
@@ -607,10 +659,12 @@ func waitForSonarQubeAnalysis(projectKey, scanID, sonarHostURL, sonarToken strin
 			return fmt.Errorf("timed out waiting for SonarQube analysis for version %s", scanID)
 		}
 
-		req, err := http.NewRequest("GET", apiURL, nil)
+		req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
 		if err != nil {
 			log.Printf("waitForSonarQubeAnalysis: could not create request: %v, retrying...", err)
-			time.Sleep(10 * time.Second)
+			if err := sleepContext(ctx, 10*time.Second); err != nil {
+				return err
+			}
 			continue
 		}
 
@@ -621,7 +675,9 @@ func waitForSonarQubeAnalysis(projectKey, scanID, sonarHostURL, sonarToken strin
 		resp, err := http.DefaultClient.Do(req)
 		if err != nil {
 			log.Printf("waitForSonarQubeAnalysis: http error: %v, retrying...", err)
-			time.Sleep(10 * time.Second)
+			if err := sleepContext(ctx, 10*time.Second); err != nil {
+				return err
+			}
 			continue
 		}
 
@@ -635,14 +691,18 @@ func waitForSonarQubeAnalysis(projectKey, scanID, sonarHostURL, sonarToken strin
 			if readErr != nil {
 				resp.Body.Close()
 				log.Printf("waitForSonarQubeAnalysis: failed to read body: %v, retrying...", readErr)
-				time.Sleep(10 * time.Second)
+				if err := sleepContext(ctx, 10*time.Second); err != nil {
+					return err
+				}
 				continue
 			}
 
 			if err := json.Unmarshal(data, &body); err != nil {
 				resp.Body.Close()
 				log.Printf("waitForSonarQubeAnalysis: failed to unmarshal json: %v, retrying...", err)
-				time.Sleep(10 * time.Second)
+				if err := sleepContext(ctx, 10*time.Second); err != nil {
+					return err
+				}
 				continue
 			}
 
@@ -659,7 +719,21 @@ func waitForSonarQubeAnalysis(projectKey, scanID, sonarHostURL, sonarToken strin
 		resp.Body.Close()
 
 		log.Printf("Waiting for SonarQube analysis for project %s with version %s...", projectKey, scanID)
-		time.Sleep(10 * time.Second)
+		if err := sleepContext(ctx, 10*time.Second); err != nil {
+			return err
+		}
+	}
+}
+
+// sleepContext sleeps for d, returning early with ctx.Err() if ctx is done.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
 	}
 }
 
@@ -676,7 +750,7 @@ func listProjectsHandler(c *gin.Context) {
 	}
 
 	rows, err := dbPool.Query(ctx, `
-        SELECT p.id, p.name, p.url, COALESCE(MAX(s.started_at), NULL) AS last_scan
+        SELECT p.id, p.name, p.url, p.source_paths, p.exclude_globs, COALESCE(MAX(s.started_at), NULL) AS last_scan
         FROM projects p
         LEFT JOIN scans s ON s.project_id = p.id
         WHERE p.user_id = $1
@@ -692,16 +766,19 @@ func listProjectsHandler(c *gin.Context) {
 	projects := make([]map[string]interface{}, 0)
 	for rows.Next() {
 		var id, name, url string
+		var sourcePaths, excludes []string
 		var lastScan *time.Time
-		if err := rows.Scan(&id, &name, &url, &lastScan); err != nil {
+		if err := rows.Scan(&id, &name, &url, &sourcePaths, &excludes, &lastScan); err != nil {
 			continue
 		}
 		projects = append(projects, map[string]interface{}{
-			"id":       id,
-			"user_id":  userID.(string),
-			"name":     name,
-			"url":      url,
-			"lastScan": lastScan,
+			"id":          id,
+			"user_id":     userID.(string),
+			"name":        name,
+			"url":         url,
+			"sourcePaths": sourcePaths,
+			"excludes":    excludes,
+			"lastScan":    lastScan,
 		})
 	}
 	c.JSON(http.StatusOK, gin.H{"projects": projects, "totalScans": totalScans})
@@ -722,8 +799,14 @@ func listProjectScansHandler(c *gin.Context) {
 		SELECT
 			s.id,
 			s.started_at,
+			s.status, s.requested_ref, s.finished_at, s.duration_ms, s.failure_reasons, s.reused_from_scan_id,
+			s.commit_sha, s.branch, s.commit_author, s.commit_date, s.commit_message,
+			COALESCE(s.detekt_config_source, ''), s.detekt_config_path, s.detekt_config_hash, s.detekt_baseline_hash,
+			s.analyzer_image, s.analyzer_image_digest, s.tool_versions,
 			(COALESCE(dr.error_issues, 0) + COALESCE(dr.warning_issues, 0) + COALESCE(dr.info_issues, 0)) as detekt_issue_count,
-			(COALESCE(sq.blocker_issues, 0) + COALESCE(sq.critical_issues, 0) + COALESCE(sq.major_issues, 0) + COALESCE(sq.minor_issues, 0) + COALESCE(sq.info_issues, 0)) as sonar_issue_count
+			dr.new_issues, dr.baseline_suppressed_issues,
+			(COALESCE(sq.blocker_issues, 0) + COALESCE(sq.critical_issues, 0) + COALESCE(sq.major_issues, 0) + COALESCE(sq.minor_issues, 0) + COALESCE(sq.info_issues, 0)) as sonar_issue_count,
+			COALESCE((SELECT jsonb_object_agg(ar.analyzer, ar.total_findings) FROM analyzer_results ar WHERE ar.scan_id = s.id), '{}') as issue_counts
 		FROM scans s
 		LEFT JOIN detekt_results dr ON s.id = dr.scan_id
 		LEFT JOIN sonarqube_results sq ON s.id = sq.scan_id
@@ -739,18 +822,54 @@ func listProjectScansHandler(c *gin.Context) {
 
 	scans := make([]map[string]interface{}, 0)
 	for rows.Next() {
-		var id string
+		var id, status string
 		var startedAt time.Time
+		var ref, reusedFrom *string
+		var provenance ScanProvenance
+		var detektConfig detektConfigUsage
+		var toolchain scanToolchain
+		var finishedAt *time.Time
+		var durationMs *int64
+		var failureReasons []scanFailure
 		var detektIssueCount, sonarIssueCount int
-		if err := rows.Scan(&id, &startedAt, &detektIssueCount, &sonarIssueCount); err != nil {
+		var detektNewIssues, detektBaselineIssues *int
+		var issueCounts map[string]int
+		if err := rows.Scan(&id, &startedAt, &status, &ref, &finishedAt, &durationMs, &failureReasons, &reusedFrom,
+			&provenance.CommitSHA, &provenance.Branch, &provenance.CommitAuthor, &provenance.CommitDate, &provenance.CommitMessage,
+			&detektConfig.Source, &detektConfig.Path, &detektConfig.ConfigHash, &detektConfig.BaselineHash,
+			&toolchain.AnalyzerImage, &toolchain.AnalyzerImageDigest, &toolchain.ToolVersions,
+			&detektIssueCount, &detektNewIssues, &detektBaselineIssues, &sonarIssueCount, &issueCounts); err != nil {
 			log.Printf("Error scanning project scans row: %v", err)
 			continue
 		}
 		scans = append(scans, map[string]interface{}{
-			"id":                 id,
-			"detectedAt":         startedAt.Format(time.RFC3339Nano),
-			"detekt_issue_count": detektIssueCount,
-			"sonar_issue_count":  sonarIssueCount,
+			"id":                          id,
+			"detectedAt":                  startedAt.Format(time.RFC3339Nano),
+			"status":                      status,
+			"ref":                         ref,
+			"reusedFromScanId":            reusedFrom,
+			"commitSha":                   provenance.CommitSHA,
+			"branch":                      provenance.Branch,
+			"commitAuthor":                provenance.CommitAuthor,
+			"commitDate":                  provenance.CommitDate,
+			"commitMessage":               provenance.CommitMessage,
+			"detektConfigSource":          nullIfEmpty(detektConfig.Source),
+			"detektConfigPath":            detektConfig.Path,
+			"detektConfigHash":            detektConfig.ConfigHash,
+			"detektBaselineHash":          detektConfig.BaselineHash,
+			"analyzerImage":               toolchain.AnalyzerImage,
+			"analyzerImageDigest":         toolchain.AnalyzerImageDigest,
+			"toolVersions":                toolchain.ToolVersions,
+			"finishedAt":                  finishedAt,
+			"durationMs":                  durationMs,
+			"failureReasons":              failureReasons,
+			"detekt_issue_count":          detektIssueCount,
+			"detekt_new_issue_count":      detektNewIssues,
+			"detekt_baseline_issue_count": detektBaselineIssues,
+			"sonar_issue_count":           sonarIssueCount,
+			"ktlint_issue_count":          issueCounts["ktlint"],
+			"android_lint_issue_count":    issueCounts["android-lint"],
+			"issueCounts":                 issueCounts,
 		})
 	}
 	c.JSON(http.StatusOK, gin.H{"scans": scans})
@@ -795,21 +914,50 @@ func getSonarQubeIssuesByScanHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, sonarRaw)
 }
 
+// getProjectAnalyticsHandler returns the trends and latest breakdowns of a
+// project. The optional "ref" query parameter restricts them to scans of one
+// branch, tag or commit; "ref=" selects scans of the default branch.
 func getProjectAnalyticsHandler(c *gin.Context) {
 	userID, _ := c.Get("userID")
 	projectID := c.Param("id")
+	ref, filterByRef := c.GetQuery("ref")
 	response := AnalyticsResponse{
-		TrendData:         make([]TrendData, 0),
-		LatestSonarRules:  make([]RuleBreakdown, 0),
-		LatestDetektRules: make([]RuleBreakdown, 0),
-		LatestNoisyFiles:  make([]FileBreakdown, 0),
+		TrendData:              make([]TrendData, 0),
+		LatestSonarRules:       make([]RuleBreakdown, 0),
+		LatestDetektRules:      make([]RuleBreakdown, 0),
+		LatestKtlintRules:      make([]RuleBreakdown, 0),
+		LatestAndroidLintRules: make([]RuleBreakdown, 0),
+		LatestNoisyFiles:       make([]FileBreakdown, 0),
+		LatestRulesByAnalyzer:  make(map[string][]RuleBreakdown),
+	}
+
+	var latest LatestScanStatus
+	err := dbPool.QueryRow(context.Background(), `
+        SELECT s.id, s.status, s.requested_ref, s.started_at, s.finished_at, s.failure_reasons
+        FROM scans s
+        WHERE s.project_id = $1 AND s.user_id = $2
+          AND ($3 = FALSE OR s.requested_ref IS NOT DISTINCT FROM NULLIF($4, ''))
+        ORDER BY s.started_at DESC LIMIT 1
+    `, projectID, userID.(string), filterByRef, ref).Scan(&latest.ScanID, &latest.Status, &latest.Ref, &latest.StartedAt, &latest.FinishedAt, &latest.FailureReasons)
+	if err == nil {
+		response.LatestScan = &latest
+	} else if err != pgx.ErrNoRows {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query latest scan status"})
+		return
 	}
 
+	// Only scans that produced results are plotted and broken down below;
+	// failed or unfinished scans would otherwise show up as zero issues.
+	// Scans that reused the results of an earlier one of the same commit
+	// would only repeat its point.
 	trendQuery := `
 		SELECT
-			s.id as scan_id, s.started_at as detected_at,
+			s.id as scan_id, s.started_at as detected_at, s.status, s.requested_ref,
+			s.commit_sha, s.branch, s.commit_author, s.commit_date, s.commit_message,
+			s.analyzer_image, s.analyzer_image_digest, s.tool_versions, s.detekt_config_hash, s.detekt_baseline_hash,
 			s.maintainability_rating, s.cognitive_complexity, s.lines_of_code,
 			(COALESCE(dr.error_issues, 0) + COALESCE(dr.warning_issues, 0) + COALESCE(dr.info_issues, 0)) as total_detekt_issues,
+			dr.new_issues, dr.baseline_suppressed_issues,
 			(COALESCE(sq.blocker_issues, 0) + COALESCE(sq.critical_issues, 0) + COALESCE(sq.major_issues, 0) + COALESCE(sq.minor_issues, 0) + COALESCE(sq.info_issues, 0)) as total_sonar_issues,
             COALESCE(sq.blocker_issues, 0) as blocker_issues,
             COALESCE(sq.critical_issues, 0) as critical_issues,
@@ -817,9 +965,11 @@ func getProjectAnalyticsHandler(c *gin.Context) {
 		FROM scans s
 		LEFT JOIN detekt_results dr ON s.id = dr.scan_id
 		LEFT JOIN sonarqube_results sq ON s.id = sq.scan_id
-		WHERE s.project_id = $1 AND s.user_id = $2 ORDER BY s.started_at ASC;
+		WHERE s.project_id = $1 AND s.user_id = $2 AND s.status = ANY($3) AND s.reused_from_scan_id IS NULL
+		  AND ($4 = FALSE OR s.requested_ref IS NOT DISTINCT FROM NULLIF($5, ''))
+		ORDER BY s.started_at ASC;
 	`
-	rows, err := dbPool.Query(context.Background(), trendQuery, projectID, userID.(string))
+	rows, err := dbPool.Query(context.Background(), trendQuery, projectID, userID.(string), scanStatusesWithResults, filterByRef, ref)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query trend data"})
 		return
@@ -829,28 +979,72 @@ func getProjectAnalyticsHandler(c *gin.Context) {
 	for rows.Next() {
 		var scan TrendData
 		err := rows.Scan(
-			&scan.ScanID, &scan.DetectedAt, &scan.MaintainabilityRating, &scan.CognitiveComplexity, &scan.LinesOfCode,
-			&scan.TotalDetektIssues, &scan.TotalSonarIssues,
+			&scan.ScanID, &scan.DetectedAt, &scan.Status, &scan.Ref,
+			&scan.CommitSHA, &scan.Branch, &scan.CommitAuthor, &scan.CommitDate, &scan.CommitMessage,
+			&scan.AnalyzerImage, &scan.AnalyzerImageDigest, &scan.ToolVersions, &scan.DetektConfigHash, &scan.DetektBaselineHash,
+			&scan.MaintainabilityRating, &scan.CognitiveComplexity, &scan.LinesOfCode,
+			&scan.TotalDetektIssues, &scan.DetektNewIssues, &scan.DetektBaselineIssues, &scan.TotalSonarIssues,
 			&scan.BlockerIssues, &scan.CriticalIssues, &scan.MajorIssues,
 		)
 		if err != nil {
 			log.Printf("Error scanning trend data row: %v", err)
 			continue
 		}
+		scan.IssuesByAnalyzer = make(map[string]int)
+		scan.ToolchainChanges = make([]string, 0)
+		if n := len(response.TrendData); n > 0 {
+			scan.ToolchainChanges = append(scan.ToolchainChanges, toolchainChanges(response.TrendData[n-1].scanToolchain, scan.scanToolchain)...)
+			scan.ToolchainChanged = len(scan.ToolchainChanges) > 0
+		}
 		response.TrendData = append(response.TrendData, scan)
 	}
 
+	trendIndex := make(map[string]int, len(response.TrendData))
+	for i, scan := range response.TrendData {
+		trendIndex[scan.ScanID] = i
+	}
+	analyzerRows, err := dbPool.Query(context.Background(), `
+        SELECT ar.scan_id, ar.analyzer, ar.total_findings
+        FROM analyzer_results ar
+        INNER JOIN scans s ON ar.scan_id = s.id
+        WHERE s.project_id = $1 AND s.user_id = $2 AND s.status = ANY($3)
+          AND ($4 = FALSE OR s.requested_ref IS NOT DISTINCT FROM NULLIF($5, ''))
+    `, projectID, userID.(string), scanStatusesWithResults, filterByRef, ref)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query analyzer trend data"})
+		return
+	}
+	for analyzerRows.Next() {
+		var scanID, analyzer string
+		var total int
+		if err := analyzerRows.Scan(&scanID, &analyzer, &total); err != nil {
+			log.Printf("Error scanning analyzer trend row: %v", err)
+			continue
+		}
+		if i, ok := trendIndex[scanID]; ok {
+			response.TrendData[i].IssuesByAnalyzer[analyzer] = total
+		}
+	}
+	analyzerRows.Close()
+	for i := range response.TrendData {
+		response.TrendData[i].TotalKtlintIssues = response.TrendData[i].IssuesByAnalyzer["ktlint"]
+		response.TrendData[i].TotalAndroidLintIssues = response.TrendData[i].IssuesByAnalyzer["android-lint"]
+	}
+
+	var latestResultScanID string
 	var latestSonarJson sql.NullString
 	var latestDetektXml sql.NullString
 	err = dbPool.QueryRow(context.Background(), `
-        SELECT sq.sonar_json, dr.detekt_xml,
+        SELECT s.id, sq.sonar_json, dr.detekt_xml,
                COALESCE(sq.bugs, 0), COALESCE(sq.vulnerabilities, 0), COALESCE(sq.code_smells, 0)
         FROM scans s
         LEFT JOIN sonarqube_results sq on s.id = sq.scan_id
         LEFT JOIN detekt_results dr on s.id = dr.scan_id
-        WHERE s.project_id = $1 AND s.user_id = $2 ORDER BY s.started_at DESC LIMIT 1
-    `, projectID, userID.(string)).Scan(
-		&latestSonarJson, &latestDetektXml,
+        WHERE s.project_id = $1 AND s.user_id = $2 AND s.status = ANY($3)
+          AND ($4 = FALSE OR s.requested_ref IS NOT DISTINCT FROM NULLIF($5, ''))
+        ORDER BY s.started_at DESC LIMIT 1
+    `, projectID, userID.(string), scanStatusesWithResults, filterByRef, ref).Scan(
+		&latestResultScanID, &latestSonarJson, &latestDetektXml,
 		&response.LatestScanData.Bugs, &response.LatestScanData.Vulnerabilities, &response.LatestScanData.CodeSmells,
 	)
 
@@ -871,6 +1065,19 @@ func getProjectAnalyticsHandler(c *gin.Context) {
 			response.LatestDetektDistribution.Infos = detektCounts.InfoIssues
 		}
 	}
+	if latestResultScanID != "" {
+		response.LatestRulesByAnalyzer, err = topRulesByAnalyzer(context.Background(), latestResultScanID, 5)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query latest findings"})
+			return
+		}
+		if rules, ok := response.LatestRulesByAnalyzer["ktlint"]; ok {
+			response.LatestKtlintRules = rules
+		}
+		if rules, ok := response.LatestRulesByAnalyzer["android-lint"]; ok {
+			response.LatestAndroidLintRules = rules
+		}
+	}
 
 	c.JSON(http.StatusOK, response)
 }
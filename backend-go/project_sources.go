@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxProjectSourceEntries caps the number of source paths and of exclude
+// globs of a project.
+const maxProjectSourceEntries = 50
+
+// projectSources restricts the analysis to part of a repository, typically
+// a module of a monorepo. An empty SourcePaths analyzes the whole repository.
+type projectSources struct {
+	// SourcePaths are directories relative to the repository root, sorted
+	// and without duplicates.
+	SourcePaths []string `json:"sourcePaths"`
+	// Excludes are globs relative to the repository root, e.g. "legacy/**".
+	Excludes []string `json:"excludes"`
+}
+
+var (
+	sourcePathPattern  = regexp.MustCompile(`^[A-Za-z0-9._/-]+$`)
+	excludeGlobPattern = regexp.MustCompile(`^[A-Za-z0-9._/*?{}\[\]-]+$`)
+	gradleModuleRegexp = regexp.MustCompile(`^(:[A-Za-z0-9._-]+)+$`)
+)
+
+// normalizeSourcePaths validates source paths and returns them cleaned,
+// sorted and deduplicated. Gradle module paths such as ":feature:login" are
+// turned into their directory, "feature/login".
+func normalizeSourcePaths(paths []string) ([]string, error) {
+	if len(paths) > maxProjectSourceEntries {
+		return nil, fmt.Errorf("at most %d source paths are allowed", maxProjectSourceEntries)
+	}
+	seen := map[string]bool{}
+	normalized := make([]string, 0, len(paths))
+	for _, p := range paths {
+		p = strings.TrimSpace(p)
+		if gradleModuleRegexp.MatchString(p) {
+			p = strings.ReplaceAll(strings.TrimPrefix(p, ":"), ":", "/")
+		}
+		if !sourcePathPattern.MatchString(p) || strings.HasPrefix(p, "/") {
+			return nil, fmt.Errorf("invalid source path %q", p)
+		}
+		p = path.Clean(p)
+		if p == ".." || strings.HasPrefix(p, "../") {
+			return nil, fmt.Errorf("source path %q leaves the repository", p)
+		}
+		if p == "." {
+			// The whole repository, which is what no source paths mean.
+			return []string{}, nil
+		}
+		if !seen[p] {
+			seen[p] = true
+			normalized = append(normalized, p)
+		}
+	}
+	sort.Strings(normalized)
+	return normalized, nil
+}
+
+// validateExcludeGlobs checks exclude globs. Commas are not allowed since the
+// tools take their excludes as comma-separated lists.
+func validateExcludeGlobs(globs []string) ([]string, error) {
+	if len(globs) > maxProjectSourceEntries {
+		return nil, fmt.Errorf("at most %d exclude globs are allowed", maxProjectSourceEntries)
+	}
+	valid := make([]string, 0, len(globs))
+	for _, g := range globs {
+		g = strings.TrimSpace(g)
+		if !excludeGlobPattern.MatchString(g) || strings.HasPrefix(g, "/") || strings.Contains(g, "..") {
+			return nil, fmt.Errorf("invalid exclude glob %q", g)
+		}
+		valid = append(valid, g)
+	}
+	return valid, nil
+}
+
+// loadProjectSources returns the source paths and excludes of a project.
+func loadProjectSources(ctx context.Context, projectID string) (projectSources, error) {
+	var sources projectSources
+	err := dbPool.QueryRow(ctx, "SELECT source_paths, exclude_globs FROM projects WHERE id = $1", projectID).
+		Scan(&sources.SourcePaths, &sources.Excludes)
+	if err != nil {
+		return projectSources{}, fmt.Errorf("failed to load source paths of project %s: %w", projectID, err)
+	}
+	return sources, nil
+}
+
+// containerEnv passes the sources to analyze.sh as comma-separated lists.
+func (s projectSources) containerEnv() []string {
+	return []string{
+		"SOURCE_PATHS=" + strings.Join(s.SourcePaths, ","),
+		"EXCLUDE_GLOBS=" + strings.Join(s.Excludes, ","),
+	}
+}
+
+// projectSourcesTaken tells whether the user already has another project for
+// url with the same source paths.
+func projectSourcesTaken(ctx context.Context, userID, url string, sourcePaths []string, exceptID string) (bool, error) {
+	var taken bool
+	err := dbPool.QueryRow(ctx, `
+        SELECT EXISTS(SELECT 1 FROM projects
+                      WHERE user_id = $1 AND url = $2 AND source_paths = $3 AND id::text <> $4)`,
+		userID, url, sourcePaths, exceptID).Scan(&taken)
+	return taken, err
+}
+
+// createProjectHandler creates a project without scanning it, so that a
+// repository can be split into several projects with different source paths.
+func createProjectHandler(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	var req struct {
+		RepoURL     string   `json:"repoUrl" binding:"required"`
+		Name        string   `json:"name"`
+		SourcePaths []string `json:"sourcePaths"`
+		Excludes    []string `json:"excludes"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: repoUrl is required."})
+		return
+	}
+	if isUploadURL(req.RepoURL) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Projects of uploaded sources are created by POST /api/scan/upload."})
+		return
+	}
+	if isLocalRepoURL(req.RepoURL) {
+		if _, err := resolveLocalRepo(req.RepoURL); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	} else {
+		normalized, err := validateRepoURL(context.Background(), req.RepoURL)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		req.RepoURL = normalized
+	}
+	sourcePaths, err := normalizeSourcePaths(req.SourcePaths)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	excludes, err := validateExcludeGlobs(req.Excludes)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		name = projectNameFromURL(req.RepoURL)
+		if len(sourcePaths) > 0 {
+			name = fmt.Sprintf("%s (%s)", name, strings.Join(sourcePaths, ", "))
+		}
+	}
+	if len(name) > 255 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name must be at most 255 characters"})
+		return
+	}
+
+	ctx := context.Background()
+	taken, err := projectSourcesTaken(ctx, userID.(string), req.RepoURL, sourcePaths, "")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not save project"})
+		return
+	}
+	if taken {
+		c.JSON(http.StatusConflict, gin.H{"error": "A project for this repository with the same source paths already exists."})
+		return
+	}
+
+	var projectID string
+	err = dbPool.QueryRow(ctx, `
+        INSERT INTO projects (user_id, name, url, source_paths, exclude_globs)
+        VALUES ($1, $2, $3, $4, $5) RETURNING id`,
+		userID.(string), name, req.RepoURL, sourcePaths, excludes).Scan(&projectID)
+	if err != nil {
+		log.Printf("Error inserting new project %s: %v", name, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not save project"})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"id":          projectID,
+		"name":        name,
+		"url":         req.RepoURL,
+		"sourcePaths": sourcePaths,
+		"excludes":    excludes,
+	})
+}
+
+func getProjectSourcesHandler(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	projectID := c.Param("id")
+
+	var sources projectSources
+	err := dbPool.QueryRow(context.Background(),
+		"SELECT source_paths, exclude_globs FROM projects WHERE id = $1 AND user_id = $2", projectID, userID.(string),
+	).Scan(&sources.SourcePaths, &sources.Excludes)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+	c.JSON(http.StatusOK, sources)
+}
+
+// updateProjectSourcesHandler replaces the source paths and excludes of a
+// project. They apply from the next scan on.
+func updateProjectSourcesHandler(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	projectID := c.Param("id")
+
+	var req projectSources
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data."})
+		return
+	}
+	sourcePaths, err := normalizeSourcePaths(req.SourcePaths)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	excludes, err := validateExcludeGlobs(req.Excludes)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := context.Background()
+	var url string
+	err = dbPool.QueryRow(ctx, "SELECT url FROM projects WHERE id = $1 AND user_id = $2", projectID, userID.(string)).Scan(&url)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+	taken, err := projectSourcesTaken(ctx, userID.(string), url, sourcePaths, projectID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not update source paths"})
+		return
+	}
+	if taken {
+		c.JSON(http.StatusConflict, gin.H{"error": "Another project for this repository has the same source paths."})
+		return
+	}
+	_, err = dbPool.Exec(ctx, `
+        UPDATE projects SET source_paths = $1, exclude_globs = $2 WHERE id = $3 AND user_id = $4`,
+		sourcePaths, excludes, projectID, userID.(string))
+	if err != nil {
+		log.Printf("Failed to update source paths of project %s: %v", projectID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not update source paths"})
+		return
+	}
+	c.JSON(http.StatusOK, projectSources{SourcePaths: sourcePaths, Excludes: excludes})
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// defaultRepoSchemes are the transports remote repositories may be cloned
+// over unless REPO_ALLOWED_SCHEMES says otherwise. scp-style URLs
+// (git@host:owner/repo) count as ssh.
+var defaultRepoSchemes = []string{"https", "ssh"}
+
+// knownRepoSchemes are the transports that can be allowed at all; git's
+// remote helpers (ext::, fd::, ...) and file:// never can. Repositories on
+// the server are scanned through LOCAL_REPO_ROOTS instead.
+var knownRepoSchemes = map[string]string{"https": "443", "http": "80", "ssh": "22", "git": "9418"}
+
+// blockedPrefixes are address ranges that aren't covered by the netip
+// predicates used in blockedAddr but aren't public either.
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"), // carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"), // benchmarking
+	netip.MustParsePrefix("240.0.0.0/4"),
+}
+
+var (
+	remoteHelperPattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9+.-]*::`)
+	scpURLPattern       = regexp.MustCompile(`^(?:([A-Za-z0-9._-]+)@)?([A-Za-z0-9.-]+):([^/].*)$`)
+	hostnamePattern     = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?(\.[a-z0-9]([a-z0-9-]*[a-z0-9])?)*$`)
+	repoPathPattern     = regexp.MustCompile(`^[A-Za-z0-9._~+/-]+$`)
+)
+
+// repoURL is a parsed remote repository URL.
+type repoURL struct {
+	Scheme string
+	User   string // ssh only
+	Host   string // lower case, without brackets for IPv6
+	Port   string // empty for the scheme's default port
+	Path   string // without surrounding slashes and ".git" suffix
+	scp    bool   // written as user@host:path
+}
+
+// String formats the URL in its normalized form, so that
+// "https://GitHub.com/a/b.git/" and "https://github.com/a/b" are the same
+// project.
+func (u repoURL) String() string {
+	if u.scp {
+		if u.User != "" {
+			return u.User + "@" + u.Host + ":" + u.Path
+		}
+		return u.Host + ":" + u.Path
+	}
+	host := u.Host
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	if u.Port != "" {
+		host += ":" + u.Port
+	}
+	if u.User != "" {
+		host = u.User + "@" + host
+	}
+	return u.Scheme + "://" + host + "/" + u.Path
+}
+
+// parseRepoURL parses and normalizes a remote repository URL. It rejects
+// what git would read as something other than a plain URL: options, remote
+// helper transports, credentials embedded in the URL, queries.
+func parseRepoURL(raw string) (repoURL, error) {
+	raw = strings.TrimSpace(raw)
+	switch {
+	case raw == "":
+		return repoURL{}, errors.New("repository URL is empty")
+	case strings.HasPrefix(raw, "-"):
+		return repoURL{}, errors.New("repository URL must not start with \"-\"")
+	case remoteHelperPattern.MatchString(raw):
+		return repoURL{}, errors.New("git remote helper transports are not allowed")
+	case strings.ContainsAny(raw, " \t\r\n\\"):
+		return repoURL{}, errors.New("repository URL must not contain whitespace or backslashes")
+	}
+
+	var u repoURL
+	if !strings.Contains(raw, "://") {
+		m := scpURLPattern.FindStringSubmatch(raw)
+		if m == nil {
+			return repoURL{}, errors.New("repository URL must be an https:// or ssh URL")
+		}
+		u = repoURL{Scheme: "ssh", User: m[1], Host: strings.ToLower(m[2]), Path: m[3], scp: true}
+	} else {
+		parsed, err := url.Parse(raw)
+		if err != nil {
+			return repoURL{}, fmt.Errorf("invalid repository URL: %w", err)
+		}
+		u = repoURL{Scheme: strings.ToLower(parsed.Scheme), Host: strings.ToLower(parsed.Hostname()), Port: parsed.Port(), Path: parsed.Path}
+		if _, ok := knownRepoSchemes[u.Scheme]; !ok {
+			return repoURL{}, fmt.Errorf("%s:// repositories are not supported", u.Scheme)
+		}
+		if parsed.Opaque != "" || parsed.RawQuery != "" || parsed.Fragment != "" || parsed.ForceQuery {
+			return repoURL{}, errors.New("repository URL must not have a query or fragment")
+		}
+		if parsed.User != nil {
+			if _, hasPassword := parsed.User.Password(); hasPassword || u.Scheme != "ssh" {
+				return repoURL{}, errors.New("repository URL must not contain credentials; attach them to the project instead")
+			}
+			u.User = parsed.User.Username()
+		}
+		if u.Port == knownRepoSchemes[u.Scheme] {
+			u.Port = ""
+		}
+	}
+
+	if ip, err := netip.ParseAddr(u.Host); err != nil && !hostnamePattern.MatchString(u.Host) {
+		return repoURL{}, fmt.Errorf("invalid host %q", u.Host)
+	} else if err == nil {
+		u.Host = ip.String()
+	}
+	u.Path = strings.TrimSuffix(strings.Trim(u.Path, "/"), ".git")
+	if u.Path == "" || !repoPathPattern.MatchString(u.Path) {
+		return repoURL{}, errors.New("repository URL must name a repository path")
+	}
+	for _, segment := range strings.Split(u.Path, "/") {
+		if segment == "" || segment == "." || segment == ".." || strings.HasPrefix(segment, "-") {
+			return repoURL{}, errors.New("repository URL must name a repository path")
+		}
+	}
+	return u, nil
+}
+
+// repoURLPolicy is what the administrator allows scanning.
+type repoURLPolicy struct {
+	// Schemes are the allowed transports, from REPO_ALLOWED_SCHEMES.
+	Schemes []string
+	// Hosts, from REPO_ALLOWED_HOSTS, are the hosts repositories may be
+	// cloned from; "*.example.com" allows its subdomains. When empty any
+	// host resolving to public addresses is allowed. Listed hosts are
+	// trusted to resolve to private addresses, e.g. an internal GitLab.
+	Hosts []string
+}
+
+func loadRepoURLPolicy() repoURLPolicy {
+	policy := repoURLPolicy{Schemes: defaultRepoSchemes}
+	if schemes := getEnvList("REPO_ALLOWED_SCHEMES"); len(schemes) > 0 {
+		policy.Schemes = nil
+		for _, s := range schemes {
+			s = strings.ToLower(s)
+			if _, ok := knownRepoSchemes[s]; ok {
+				policy.Schemes = append(policy.Schemes, s)
+			}
+		}
+	}
+	for _, h := range getEnvList("REPO_ALLOWED_HOSTS") {
+		policy.Hosts = append(policy.Hosts, strings.ToLower(h))
+	}
+	return policy
+}
+
+func (p repoURLPolicy) schemeAllowed(scheme string) bool {
+	for _, s := range p.Schemes {
+		if s == scheme {
+			return true
+		}
+	}
+	return false
+}
+
+func (p repoURLPolicy) hostListed(host string) bool {
+	for _, h := range p.Hosts {
+		if suffix, ok := strings.CutPrefix(h, "*."); ok {
+			if strings.HasSuffix(host, "."+suffix) {
+				return true
+			}
+		} else if h == host {
+			return true
+		}
+	}
+	return false
+}
+
+// blockedAddr tells whether an address is internal to the server's network:
+// loopback, private, link-local and the like.
+func blockedAddr(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return true
+	}
+	for _, prefix := range blockedPrefixes {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkedRepo is a remote repository URL that passed the policy, with the
+// address its host was checked at.
+type checkedRepo struct {
+	URL repoURL
+	// Addr is the checked address git is to connect to instead of resolving
+	// the host again, which could by then give another address. It is not
+	// set for hosts listed in REPO_ALLOWED_HOSTS, which are trusted, nor for
+	// hosts that are addresses already.
+	Addr netip.Addr
+}
+
+// validateRepoURL checks a remote repository URL against the policy and
+// returns it normalized. Unless the host is listed in REPO_ALLOWED_HOSTS,
+// every address it resolves to must be public, so that scans can't be
+// pointed at the server's own network. It is checked when a scan is
+// requested and again when it runs.
+func validateRepoURL(ctx context.Context, raw string) (string, error) {
+	repo, err := checkRepoURL(ctx, raw)
+	if err != nil {
+		return "", err
+	}
+	return repo.URL.String(), nil
+}
+
+// checkRepoURL is validateRepoURL, returning the address that was checked.
+func checkRepoURL(ctx context.Context, raw string) (checkedRepo, error) {
+	u, err := parseRepoURL(raw)
+	if err != nil {
+		return checkedRepo{}, err
+	}
+	policy := loadRepoURLPolicy()
+	if !policy.schemeAllowed(u.Scheme) {
+		return checkedRepo{}, fmt.Errorf("%s repositories are not allowed on this server", u.Scheme)
+	}
+	if len(policy.Hosts) > 0 {
+		if !policy.hostListed(u.Host) {
+			return checkedRepo{}, fmt.Errorf("repositories on %s are not allowed on this server", u.Host)
+		}
+		return checkedRepo{URL: u}, nil
+	}
+	if ip, err := netip.ParseAddr(u.Host); err == nil {
+		if blockedAddr(ip) {
+			return checkedRepo{}, fmt.Errorf("host %s is a non-public address", u.Host)
+		}
+		return checkedRepo{URL: u}, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", u.Host)
+	if err != nil || len(addrs) == 0 {
+		return checkedRepo{}, fmt.Errorf("host %s could not be resolved", u.Host)
+	}
+	for _, addr := range addrs {
+		if blockedAddr(addr) {
+			return checkedRepo{}, fmt.Errorf("host %s resolves to a non-public address", u.Host)
+		}
+	}
+	return checkedRepo{URL: u, Addr: pinnedAddr(addrs)}, nil
+}
+
+// pinnedAddr picks the address to connect to out of those a host resolves
+// to. IPv4 is preferred as containers often have no IPv6 connectivity.
+func pinnedAddr(addrs []netip.Addr) netip.Addr {
+	for _, addr := range addrs {
+		if addr.Unmap().Is4() {
+			return addr.Unmap()
+		}
+	}
+	return addrs[0]
+}
+
+// curlResolve is the http.curloptResolve entry that makes git connect to the
+// checked address over http(s). The host name is still used for TLS.
+func (r checkedRepo) curlResolve() string {
+	if !r.Addr.IsValid() || (r.URL.Scheme != "https" && r.URL.Scheme != "http") {
+		return ""
+	}
+	port := r.URL.Port
+	if port == "" {
+		port = knownRepoSchemes[r.URL.Scheme]
+	}
+	addr := r.Addr.String()
+	if r.Addr.Is6() {
+		addr = "[" + addr + "]"
+	}
+	return r.URL.Host + ":" + port + ":" + addr
+}
+
+// sshOptions are the ssh options that make git connect to the checked
+// address over ssh, keeping the host name for host key checking.
+func (r checkedRepo) sshOptions() string {
+	if !r.Addr.IsValid() || r.URL.Scheme != "ssh" {
+		return ""
+	}
+	return "-o HostName=" + r.Addr.String() + " -o HostKeyAlias=" + r.URL.Host
+}
+
+// containerEnv passes the checked address to analyze.sh. The git://
+// transport can't be pinned; its host is resolved again when cloning.
+func (r checkedRepo) containerEnv() []string {
+	return []string{
+		"REPO_CURL_RESOLVE=" + r.curlResolve(),
+		"REPO_SSH_OPTIONS=" + r.sshOptions(),
+	}
+}
+
+// gitAllowProtocol is the GIT_ALLOW_PROTOCOL the analysis container clones
+// with, so that git itself refuses any other transport, e.g. in a redirect.
+func gitAllowProtocol(repoURL string) string {
+	if isLocalRepoURL(repoURL) {
+		return "file"
+	}
+	return strings.Join(loadRepoURLPolicy().Schemes, ":")
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/docker/go-units"
+)
+
+// dockerRunner runs analyze.sh in a container of the repo-analyzer image,
+// with the scan's directories bind-mounted and its limits applied to the
+// container.
+type dockerRunner struct{}
+
+func (dockerRunner) sonarHostURL() string {
+	return "http://host.docker.internal:9000"
+}
+
+func (dockerRunner) repoDir(string) string {
+	return "/data/repo"
+}
+
+func (dockerRunner) run(ctx context.Context, r analysisRun) (analyzerImage, error) {
+	in := r.Inputs
+
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return analyzerImage{}, fmt.Errorf("docker client error: %w", err)
+	}
+	defer cli.Close()
+
+	env := r.Env
+	mounts := []mount.Mount{{Type: mount.TypeBind, Source: in.OutputDir, Target: "/data"}}
+	if in.SourceDir != "" {
+		env = append(env, fmt.Sprintf("SOURCE_DIR=%s", sourceMountPath))
+		mounts = append(mounts, mount.Mount{Type: mount.TypeBind, Source: in.SourceDir, Target: sourceMountPath, ReadOnly: true})
+	}
+	if in.DetektConfigDir != "" {
+		mounts = append(mounts, mount.Mount{Type: mount.TypeBind, Source: in.DetektConfigDir, Target: detektConfigMountPath, ReadOnly: true})
+	}
+	if in.LocalRepoDir != "" {
+		env = append(env, fmt.Sprintf("LOCAL_REPO=%s", localRepoMountPath))
+		mounts = append(mounts, mount.Mount{Type: mount.TypeBind, Source: in.LocalRepoDir, Target: localRepoMountPath, ReadOnly: true})
+	}
+	if in.Secrets != nil {
+		mounts = append(mounts, mount.Mount{Type: mount.TypeBind, Source: in.Secrets.Dir, Target: cloneSecretsMountPath})
+	}
+
+	// The container is created from the ID the reference resolves to now,
+	// so that the recorded digest is that of the image that ran even if the
+	// tag moves meanwhile.
+	img, imageID, err := inspectAnalyzerImage(ctx, cli)
+	if err != nil {
+		return img, err
+	}
+
+	resp, err := cli.ContainerCreate(ctx, &container.Config{
+		Image: imageID,
+		Env:   env,
+		Tty:   false,
+	}, &container.HostConfig{
+		Mounts:    mounts,
+		Resources: r.Limits.containerResources(),
+	}, nil, nil, "")
+	if err != nil {
+		return img, fmt.Errorf("failed to create container: %w", err)
+	}
+	// The container is removed here rather than with AutoRemove so that it can
+	// still be inspected for an OOM kill after it exits.
+	defer removeAnalysisContainer(cli, resp.ID)
+
+	if err := cli.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
+		return img, fmt.Errorf("failed to start container: %w", err)
+	}
+
+	logReader, err := cli.ContainerLogs(ctx, resp.ID, container.LogsOptions{ShowStdout: true, ShowStderr: true, Follow: true})
+	if err != nil {
+		log.Printf("Error getting container logs: %v", err)
+	} else {
+		defer logReader.Close()
+		go func() {
+			stdcopy.StdCopy(r.Stdout, r.Stderr, logReader)
+		}()
+	}
+
+	log.Printf("Analysis container %s started. Waiting for completion...", resp.ID[:12])
+	r.Info(fmt.Sprintf("Analysis container %s started.", resp.ID[:12]))
+
+	statusCh, errCh := cli.ContainerWait(ctx, resp.ID, container.WaitConditionNotRunning)
+	select {
+	case err := <-errCh:
+		if ctx.Err() != nil {
+			log.Printf("Stopping container %s of scan %s", resp.ID[:12], r.ScanID)
+			stopAnalysisContainer(cli, resp.ID)
+			return img, ctx.Err()
+		}
+		if err != nil {
+			return img, fmt.Errorf("container execution error: %w", err)
+		}
+	case status := <-statusCh:
+		if status.StatusCode != 0 {
+			inspect, inspectErr := cli.ContainerInspect(context.Background(), resp.ID)
+			if inspectErr == nil && inspect.State != nil && inspect.State.OOMKilled {
+				return img, &resourceLimitError{Limit: fmt.Sprintf("memory limit of %s", units.BytesSize(float64(r.Limits.MemoryBytes)))}
+			}
+			return img, fmt.Errorf("analysis container exited with non-zero status: %d", status.StatusCode)
+		}
+		log.Printf("Container %s finished successfully.", resp.ID[:12])
+		r.Info("Analysis container finished successfully.")
+	}
+
+	return img, nil
+}
+
+func (dockerRunner) resolveImage(ctx context.Context) (analyzerImage, error) {
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return analyzerImage{}, fmt.Errorf("docker client error: %w", err)
+	}
+	defer cli.Close()
+	img, _, err := inspectAnalyzerImage(ctx, cli)
+	return img, err
+}
+
+// inspectAnalyzerImage resolves ANALYZER_IMAGE to the image it currently
+// names, and returns the ID of that image as well.
+func inspectAnalyzerImage(ctx context.Context, cli *client.Client) (analyzerImage, string, error) {
+	img := analyzerImage{Ref: analyzerImageRef()}
+	inspect, err := cli.ImageInspect(ctx, img.Ref)
+	if err != nil {
+		return img, "", fmt.Errorf("failed to inspect analyzer image %s: %w", img.Ref, err)
+	}
+	img.Digest = imageDigest(inspect)
+	return img, inspect.ID, nil
+}
+
+// imageDigest identifies the content of an image: its registry digest, or
+// its ID for images that were built locally and never pushed.
+func imageDigest(inspect image.InspectResponse) string {
+	if len(inspect.RepoDigests) > 0 {
+		return inspect.RepoDigests[0]
+	}
+	return inspect.ID
+}
+
+// stopAnalysisContainer kills a container whose scan was cancelled or which
+// exceeded one of its limits.
+func stopAnalysisContainer(cli *client.Client, containerID string) {
+	timeout := 0
+	if err := cli.ContainerStop(context.Background(), containerID, container.StopOptions{Timeout: &timeout}); err != nil {
+		log.Printf("Failed to stop container %s: %v", containerID[:12], err)
+	}
+}
+
+func removeAnalysisContainer(cli *client.Client, containerID string) {
+	if err := cli.ContainerRemove(context.Background(), containerID, container.RemoveOptions{Force: true}); err != nil {
+		log.Printf("Failed to remove container %s: %v", containerID[:12], err)
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// localEnvPassthrough are the variables of the backend's own environment
+// that analyze.sh gets from the local runner, for it to find the tools.
+// Everything else, such as DB_URL, is kept from it.
+var localEnvPassthrough = []string{"PATH", "JAVA_HOME", "LANG", "LC_ALL", "SONAR_USER_HOME"}
+
+// localRunner runs analyze.sh as a process of the backend, for hosts without
+// Docker and for testing the pipeline end to end. git and the tools analyze.sh
+// calls (detekt, ktlint, sonar-scanner) must be in the PATH, or be stand-ins
+// for them. The scan's directories are passed as they are, and each run gets
+// a scratch directory as its HOME and TMPDIR. Only the runtime and disk
+// limits are enforced.
+type localRunner struct {
+	Script       string // path of analyze.sh, from SCAN_LOCAL_SCRIPT
+	AnalyzersDir string // extra analyzers, from SCAN_LOCAL_ANALYZERS_DIR; empty for none
+}
+
+// newLocalRunner finds analyze.sh, by default in the analysis-docker
+// directory next to the backend's working directory.
+func newLocalRunner() (localRunner, error) {
+	script := os.Getenv("SCAN_LOCAL_SCRIPT")
+	if script == "" {
+		script = filepath.Join("..", "analysis-docker", "analyze.sh")
+	}
+	script, err := filepath.Abs(script)
+	if err != nil {
+		return localRunner{}, err
+	}
+	if _, err := os.Stat(script); err != nil {
+		return localRunner{}, fmt.Errorf("analysis script for the local runner not found, set SCAN_LOCAL_SCRIPT: %w", err)
+	}
+	return localRunner{Script: script, AnalyzersDir: os.Getenv("SCAN_LOCAL_ANALYZERS_DIR")}, nil
+}
+
+func (localRunner) sonarHostURL() string {
+	if url := os.Getenv("SONAR_HOST_URL"); url != "" {
+		return url
+	}
+	return "http://localhost:9000"
+}
+
+// repoDir is where analyze.sh clones into, as the output dir is passed to
+// it as DATA_DIR.
+func (localRunner) repoDir(outputDir string) string {
+	return filepath.Join(outputDir, "repo")
+}
+
+// localTools are the tools analyze.sh runs from the PATH under the local
+// runner.
+var localTools = []string{"detekt", "ktlint", "sonar-scanner"}
+
+// digest stands in for an image digest: there is no image, so it hashes the
+// script and the versions the tools on the PATH report. Like an image digest
+// it changes when the toolchain does. Tools that aren't installed are hashed
+// as missing.
+func (l localRunner) digest(ctx context.Context) (string, error) {
+	script, err := os.ReadFile(l.Script)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", l.Script, err)
+	}
+	h := sha256.New()
+	h.Write(script)
+	for _, tool := range localTools {
+		fmt.Fprintf(h, "\x00%s=", tool)
+		path, err := exec.LookPath(tool)
+		if err != nil {
+			continue
+		}
+		versionCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		out, _ := exec.CommandContext(versionCtx, path, "--version").Output()
+		cancel()
+		h.Write(out)
+	}
+	return fmt.Sprintf("sha256:%x", h.Sum(nil)), nil
+}
+
+func (l localRunner) run(ctx context.Context, r analysisRun) (analyzerImage, error) {
+	in := r.Inputs
+
+	img, err := l.resolveImage(ctx)
+	if err != nil {
+		return img, err
+	}
+
+	scratchDir, err := os.MkdirTemp("", "scan-home-")
+	if err != nil {
+		return img, fmt.Errorf("failed to create scratch dir: %w", err)
+	}
+	defer os.RemoveAll(scratchDir)
+
+	env := append([]string{}, r.Env...)
+	for _, name := range localEnvPassthrough {
+		if value, ok := os.LookupEnv(name); ok {
+			env = append(env, name+"="+value)
+		}
+	}
+	env = append(env,
+		"HOME="+scratchDir,
+		"TMPDIR="+scratchDir,
+		"GIT_CONFIG_GLOBAL="+filepath.Join(scratchDir, ".gitconfig"),
+		"DATA_DIR="+in.OutputDir,
+		"SOURCE_DIR="+in.SourceDir,
+		"LOCAL_REPO="+in.LocalRepoDir,
+		"DETEKT_CONFIG_DIR="+in.DetektConfigDir,
+		"ANALYZERS_DIR="+l.AnalyzersDir,
+	)
+	if in.Secrets != nil {
+		env = append(env, "SECRETS_DIR="+in.Secrets.Dir)
+	}
+
+	// os/exec copies stdout and stderr concurrently; the log lines are
+	// handled one at a time as with the container's output.
+	var mu sync.Mutex
+	cmd := exec.CommandContext(ctx, "/bin/bash", l.Script)
+	cmd.Env = env
+	cmd.Dir = scratchDir
+	cmd.Stdout = &lockedWriter{mu: &mu, w: r.Stdout}
+	cmd.Stderr = &lockedWriter{mu: &mu, w: r.Stderr}
+	// Cancelling kills analyze.sh and everything it started, and stops
+	// waiting for output of processes that escaped.
+	startProcessGroup(cmd)
+	cmd.WaitDelay = 10 * time.Second
+
+	if r.Limits.MemoryBytes > 0 || r.Limits.CPUs > 0 || r.Limits.PidsLimit > 0 {
+		r.Info("The local runner doesn't enforce memory, CPU or process limits.")
+	}
+	if err := cmd.Start(); err != nil {
+		return img, fmt.Errorf("failed to start %s: %w", l.Script, err)
+	}
+	log.Printf("Analysis process %d of scan %s started. Waiting for completion...", cmd.Process.Pid, r.ScanID)
+	r.Info(fmt.Sprintf("Analysis process %d started.", cmd.Process.Pid))
+
+	err = cmd.Wait()
+	if ctx.Err() != nil {
+		return img, ctx.Err()
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return img, fmt.Errorf("analysis process exited with non-zero status: %d", exitErr.ExitCode())
+	}
+	if err != nil {
+		return img, fmt.Errorf("analysis process error: %w", err)
+	}
+	r.Info("Analysis process finished successfully.")
+	return img, nil
+}
+
+// resolveImage stands the script and the tools on the PATH in for the
+// image.
+func (l localRunner) resolveImage(ctx context.Context) (analyzerImage, error) {
+	digest, err := l.digest(ctx)
+	if err != nil {
+		return analyzerImage{}, err
+	}
+	return analyzerImage{Ref: "local:" + l.Script, Digest: digest}, nil
+}
+
+type lockedWriter struct {
+	mu *sync.Mutex
+	w  io.Writer
+}
+
+func (w *lockedWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.w.Write(p)
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+import "os/exec"
+
+// startProcessGroup is a no-op where process groups aren't available;
+// cancelling cmd only kills analyze.sh itself.
+func startProcessGroup(cmd *exec.Cmd) {}
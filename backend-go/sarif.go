@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// sarifDir is where tools run in the analysis container leave SARIF 2.1.0
+// reports, relative to the /data directory.
+const sarifDir = "sarif"
+
+// sarifLog is the part of a SARIF 2.1.0 log DP reads.
+type sarifLog struct {
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool struct {
+		Driver struct {
+			Name            string      `json:"name"`
+			Version         string      `json:"version"`
+			SemanticVersion string      `json:"semanticVersion"`
+			Rules           []sarifRule `json:"rules"`
+		} `json:"driver"`
+	} `json:"tool"`
+	Results []struct {
+		RuleID    string `json:"ruleId"`
+		RuleIndex *int   `json:"ruleIndex"`
+		Rule      *struct {
+			ID    string `json:"id"`
+			Index *int   `json:"index"`
+		} `json:"rule"`
+		Kind    string       `json:"kind"`
+		Level   string       `json:"level"`
+		Message sarifMessage `json:"message"`
+		// Only the first physical location of a result is kept.
+		Locations []struct {
+			PhysicalLocation struct {
+				ArtifactLocation struct {
+					URI string `json:"uri"`
+				} `json:"artifactLocation"`
+				Region struct {
+					StartLine int `json:"startLine"`
+				} `json:"region"`
+			} `json:"physicalLocation"`
+		} `json:"locations"`
+	} `json:"results"`
+}
+
+type sarifRule struct {
+	ID                   string       `json:"id"`
+	ShortDescription     sarifMessage `json:"shortDescription"`
+	DefaultConfiguration struct {
+		Level string `json:"level"`
+	} `json:"defaultConfiguration"`
+	Properties struct {
+		Tags []string `json:"tags"`
+	} `json:"properties"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+// sarifLevels maps SARIF result levels onto the common severities.
+var sarifLevels = map[string]string{
+	"error":   severityCritical,
+	"warning": severityMajor,
+	"note":    severityMinor,
+	"none":    severityInfo,
+}
+
+// sarifAnalyzer is the analyzer standing for one tool found in SARIF reports.
+// It is not registered: instances are created from the tool names in the
+// reports, so any tool that writes SARIF can be ingested.
+type sarifAnalyzer struct {
+	name    string
+	version string
+}
+
+func (a sarifAnalyzer) Name() string    { return a.name }
+func (a sarifAnalyzer) Version() string { return a.version }
+
+func (a sarifAnalyzer) Run(ctx context.Context, ws *analysisWorkspace) ([]byte, error) {
+	return nil, errNoReport
+}
+
+func (a sarifAnalyzer) Parse(raw []byte) ([]Finding, error) {
+	var sarif sarifLog
+	if err := json.Unmarshal(raw, &sarif); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal sarif log: %w", err)
+	}
+	findings := make([]Finding, 0)
+	for _, run := range sarif.Runs {
+		findings = append(findings, sarifRunFindings(run)...)
+	}
+	return findings, nil
+}
+
+func (a sarifAnalyzer) Summarize(findings []Finding) AnalyzerSummary {
+	return summarizeFindings(findings)
+}
+
+func sarifRunFindings(run sarifRun) []Finding {
+	rules := run.Tool.Driver.Rules
+	rulesByID := make(map[string]sarifRule, len(rules))
+	for _, rule := range rules {
+		rulesByID[rule.ID] = rule
+	}
+
+	findings := make([]Finding, 0, len(run.Results))
+	for _, result := range run.Results {
+		// Results that record a check passing or not applying are not issues.
+		if result.Kind == "pass" || result.Kind == "notApplicable" {
+			continue
+		}
+
+		var rule sarifRule
+		ruleID, ruleIndex := result.RuleID, result.RuleIndex
+		if result.Rule != nil {
+			if ruleID == "" {
+				ruleID = result.Rule.ID
+			}
+			if ruleIndex == nil {
+				ruleIndex = result.Rule.Index
+			}
+		}
+		if ruleIndex != nil && *ruleIndex >= 0 && *ruleIndex < len(rules) {
+			rule = rules[*ruleIndex]
+			if ruleID == "" {
+				ruleID = rule.ID
+			}
+		} else if r, ok := rulesByID[ruleID]; ok {
+			rule = r
+		}
+
+		// The level falls back to the rule's default, then to SARIF's own
+		// default of "warning".
+		level := result.Level
+		if level == "" {
+			level = rule.DefaultConfiguration.Level
+		}
+		if level == "" {
+			level = "warning"
+		}
+		severity, ok := sarifLevels[level]
+		if !ok {
+			severity = severityInfo
+		}
+
+		finding := Finding{
+			RuleID:   ruleID,
+			Severity: severity,
+			Message:  result.Message.Text,
+		}
+		if finding.Message == "" {
+			finding.Message = rule.ShortDescription.Text
+		}
+		if len(rule.Properties.Tags) > 0 {
+			finding.Category = strings.ToLower(rule.Properties.Tags[0])
+		}
+		if len(result.Locations) > 0 {
+			loc := result.Locations[0].PhysicalLocation
+			finding.File = sarifFilePath(loc.ArtifactLocation.URI)
+			finding.Line = loc.Region.StartLine
+		}
+		if finding.RuleID == "" {
+			finding.RuleID = "unknown"
+		}
+		findings = append(findings, finding)
+	}
+	return findings
+}
+
+// sarifFilePath turns an artifact URI into a file path. URIs relative to the
+// source root stay relative.
+func sarifFilePath(uri string) string {
+	if u, err := url.Parse(uri); err == nil && (u.Scheme == "file" || u.Scheme == "") && u.Path != "" {
+		return u.Path
+	}
+	return uri
+}
+
+var sarifToolNameCleaner = regexp.MustCompile(`[^a-z0-9]+`)
+
+// sarifAnalyzerName derives the analyzer name of a SARIF tool. Tools that
+// also have a native analyzer, e.g. detekt, get a "-sarif" suffix so the two
+// are stored apart.
+func sarifAnalyzerName(tool string) string {
+	name := strings.Trim(sarifToolNameCleaner.ReplaceAllString(strings.ToLower(tool), "-"), "-")
+	if name == "" {
+		name = "unknown"
+	}
+	if len(name) > 40 {
+		name = name[:40]
+	}
+	if _, native := findAnalyzer(name); native || name == "sarif" {
+		name += "-sarif"
+	}
+	return name
+}
+
+// parseSarifReports splits SARIF logs into one analyzer output per tool. Runs
+// of the same tool, from one log or several, are merged. File paths are made
+// relative to repoDir as by parseAnalyzerReport.
+func parseSarifReports(logs [][]byte, repoDir string) ([]analyzerOutput, error) {
+	type toolRuns struct {
+		analyzer sarifAnalyzer
+		runs     []json.RawMessage
+	}
+	byTool := make(map[string]*toolRuns)
+	for _, raw := range logs {
+		var sarif struct {
+			Version string            `json:"version"`
+			Runs    []json.RawMessage `json:"runs"`
+		}
+		if err := json.Unmarshal(raw, &sarif); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal sarif log: %w", err)
+		}
+		if sarif.Version != "2.1.0" {
+			return nil, fmt.Errorf("unsupported sarif version %q, expected 2.1.0", sarif.Version)
+		}
+		for _, rawRun := range sarif.Runs {
+			var run sarifRun
+			if err := json.Unmarshal(rawRun, &run); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal sarif run: %w", err)
+			}
+			driver := run.Tool.Driver
+			if driver.Name == "" {
+				return nil, errors.New("sarif run without tool.driver.name")
+			}
+			name := sarifAnalyzerName(driver.Name)
+			tr, ok := byTool[name]
+			if !ok {
+				version := driver.SemanticVersion
+				if version == "" {
+					version = driver.Version
+				}
+				if version == "" {
+					version = "unknown"
+				}
+				tr = &toolRuns{analyzer: sarifAnalyzer{name: name, version: version}}
+				byTool[name] = tr
+			}
+			tr.runs = append(tr.runs, rawRun)
+		}
+	}
+
+	names := make([]string, 0, len(byTool))
+	for name := range byTool {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	outputs := make([]analyzerOutput, 0, len(names))
+	for _, name := range names {
+		tr := byTool[name]
+		raw, err := json.Marshal(struct {
+			Version string            `json:"version"`
+			Runs    []json.RawMessage `json:"runs"`
+		}{"2.1.0", tr.runs})
+		if err != nil {
+			return nil, err
+		}
+		out, err := parseAnalyzerReport(tr.analyzer, raw, repoDir)
+		if err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, out)
+	}
+	return outputs, nil
+}
+
+// collectSarifReports ingests the SARIF logs tools left in the sarif
+// directory of a scan's workspace.
+func collectSarifReports(ws *analysisWorkspace) ([]analyzerOutput, []scanFailure) {
+	paths, _ := filepath.Glob(filepath.Join(ws.OutputDir, sarifDir, "*.sarif"))
+	more, _ := filepath.Glob(filepath.Join(ws.OutputDir, sarifDir, "*.sarif.json"))
+	paths = append(paths, more...)
+	if len(paths) == 0 {
+		return nil, nil
+	}
+
+	var failures []scanFailure
+	logs := make([][]byte, 0, len(paths))
+	for _, path := range paths {
+		raw, err := os.ReadFile(path)
+		if err == nil {
+			_, err = parseSarifReports([][]byte{raw}, ws.RepoDir)
+		}
+		if err != nil {
+			log.Printf("Warning: Skipping SARIF report %s of scan %s: %v", filepath.Base(path), ws.ScanID, err)
+			failures = append(failures, scanFailure{Phase: "sarif", Reason: fmt.Sprintf("%s could not be parsed: %v", filepath.Base(path), err)})
+			continue
+		}
+		logs = append(logs, raw)
+	}
+	outputs, err := parseSarifReports(logs, ws.RepoDir)
+	if err != nil {
+		failures = append(failures, scanFailure{Phase: "sarif", Reason: failureReason(err)})
+	}
+	return outputs, failures
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSarifParse(t *testing.T) {
+	raw := `{
+  "version": "2.1.0",
+  "runs": [{
+    "tool": {"driver": {"name": "Semgrep", "rules": [
+      {"id": "kotlin.sql-injection", "shortDescription": {"text": "SQL built from input"},
+       "defaultConfiguration": {"level": "error"}, "properties": {"tags": ["Security", "CWE-89"]}},
+      {"id": "kotlin.todo", "properties": {"tags": []}}
+    ]}},
+    "results": [
+      {"ruleId": "kotlin.sql-injection", "message": {"text": "User input reaches a query"},
+       "locations": [{"physicalLocation": {"artifactLocation": {"uri": "src/Db.kt"}, "region": {"startLine": 42}}}]},
+      {"ruleIndex": 1, "level": "note", "message": {"text": "TODO left"},
+       "locations": [{"physicalLocation": {"artifactLocation": {"uri": "file:///tmp/scan-output-1/repo/src/App.kt"}, "region": {"startLine": 7}}}]},
+      {"rule": {"id": "kotlin.sql-injection"}, "level": "none"},
+      {"ruleId": "kotlin.todo", "kind": "pass", "message": {"text": "fine"}},
+      {"ruleId": "kotlin.todo", "kind": "notApplicable"},
+      {"level": "bogus", "message": {"text": "no rule"}}
+    ]
+  }]
+}`
+	want := []Finding{
+		{RuleID: "kotlin.sql-injection", Severity: severityCritical, Category: "security", Message: "User input reaches a query", File: "src/Db.kt", Line: 42},
+		{RuleID: "kotlin.todo", Severity: severityMinor, Message: "TODO left", File: "/tmp/scan-output-1/repo/src/App.kt", Line: 7},
+		{RuleID: "kotlin.sql-injection", Severity: severityInfo, Category: "security", Message: "SQL built from input"},
+		{RuleID: "unknown", Severity: severityInfo, Message: "no rule"},
+	}
+	got, err := sarifAnalyzer{name: "semgrep"}.Parse([]byte(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Parse() =\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestSarifLevelDefaultsToWarning(t *testing.T) {
+	raw := `{"version": "2.1.0", "runs": [{"tool": {"driver": {"name": "t"}}, "results": [{"ruleId": "r", "message": {"text": "m"}}]}]}`
+	got, err := sarifAnalyzer{name: "t"}.Parse([]byte(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Severity != severityMajor {
+		t.Errorf("Parse() = %+v, want one major finding", got)
+	}
+}
+
+func TestSarifFilePath(t *testing.T) {
+	tests := []struct {
+		uri  string
+		want string
+	}{
+		{"src/App.kt", "src/App.kt"},
+		{"file:///data/repo/src/App.kt", "/data/repo/src/App.kt"},
+		{"/data/repo/src/App.kt", "/data/repo/src/App.kt"},
+		{"src/My%20File.kt", "src/My File.kt"},
+		{"https://example.com/App.kt", "https://example.com/App.kt"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := sarifFilePath(tt.uri); got != tt.want {
+			t.Errorf("sarifFilePath(%q) = %q, want %q", tt.uri, got, tt.want)
+		}
+	}
+}
+
+func TestSarifAnalyzerName(t *testing.T) {
+	tests := []struct {
+		tool string
+		want string
+	}{
+		{"Semgrep OSS", "semgrep-oss"},
+		{"  CodeQL  ", "codeql"},
+		{"detekt", "detekt-sarif"},
+		{"SARIF", "sarif-sarif"},
+		{"***", "unknown"},
+		{"a-very-long-tool-name-that-goes-on-and-on-forever", "a-very-long-tool-name-that-goes-on-and-o"},
+	}
+	for _, tt := range tests {
+		if got := sarifAnalyzerName(tt.tool); got != tt.want {
+			t.Errorf("sarifAnalyzerName(%q) = %q, want %q", tt.tool, got, tt.want)
+		}
+	}
+}
+
+func TestParseSarifReports(t *testing.T) {
+	logA := []byte(`{"version": "2.1.0", "runs": [
+  {"tool": {"driver": {"name": "Semgrep", "semanticVersion": "1.50.0"}}, "results": [{"ruleId": "a", "message": {"text": "m"}}]},
+  {"tool": {"driver": {"name": "Trivy", "version": "0.48"}}, "results": []}
+]}`)
+	logB := []byte(`{"version": "2.1.0", "runs": [
+  {"tool": {"driver": {"name": "semgrep"}}, "results": [{"ruleId": "b", "message": {"text": "m"},
+    "locations": [{"physicalLocation": {"artifactLocation": {"uri": "file:///tmp/out/repo/src/App.kt"}}}]}]}
+]}`)
+	outputs, err := parseSarifReports([][]byte{logA, logB}, "/tmp/out/repo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	type summary struct {
+		name, version string
+		findings      int
+	}
+	var got []summary
+	for _, out := range outputs {
+		got = append(got, summary{out.Analyzer.Name(), out.Version, len(out.Findings)})
+	}
+	want := []summary{{"semgrep", "1.50.0", 2}, {"trivy", "0.48", 0}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseSarifReports() = %+v, want %+v", got, want)
+	}
+	if len(outputs) > 0 && len(outputs[0].Findings) == 2 {
+		if file := outputs[0].Findings[1].File; file != "src/App.kt" {
+			t.Errorf("file = %q, want it relative to the repository", file)
+		}
+	}
+
+	for name, raw := range map[string]string{
+		"not JSON":         `<xml/>`,
+		"wrong version":    `{"version": "2.0.0", "runs": []}`,
+		"run without tool": `{"version": "2.1.0", "runs": [{"results": []}]}`,
+	} {
+		if _, err := parseSarifReports([][]byte{[]byte(raw)}, ""); err == nil {
+			t.Errorf("parseSarifReports(%s) succeeded, want an error", name)
+		}
+	}
+}
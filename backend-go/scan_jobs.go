@@ -0,0 +1,634 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// Values of scan_jobs.status. They describe the queue bookkeeping only; the
+// user-facing outcome of a scan is scans.status (see scan_status.go).
+const (
+	jobStatusQueued    = "queued"
+	jobStatusRunning   = "running"
+	jobStatusCompleted = "completed"
+	jobStatusFailed    = "failed"
+	jobStatusCancelled = "cancelled"
+)
+
+// Progress phases of a running scan. The first four are announced by
+// analyze.sh through "::phase::<name>" marker lines in the container output.
+const (
+	scanPhaseCloning         = "cloning"
+	scanPhaseDetekt          = "detekt"
+	scanPhaseKtlint          = "ktlint"
+	scanPhaseSonarUpload     = "sonar-upload"
+	scanPhaseSonarProcessing = "sonar-processing"
+	scanPhaseIngesting       = "ingesting"
+)
+
+const phaseMarkerPrefix = "::phase::"
+
+// scanQueueLockKey is the advisory lock taken while claiming a job, so that
+// backends sharing the database can't race past SCAN_MAX_RUNNING.
+const scanQueueLockKey = 742001
+
+type scanJob struct {
+	ID              string
+	ScanID          string
+	ProjectID       string
+	UserID          string
+	RepoURL         string
+	Ref             string // branch, tag or commit to check out; empty for the default branch
+	Commit          string // commit to check out after Ref, e.g. the one a push webhook reported
+	Force           bool   // run the analysis even if the commit was analyzed before
+	SonarProjectKey string
+	Attempts        int
+}
+
+type scanQueueConfig struct {
+	// Workers is the number of jobs this backend instance runs concurrently.
+	Workers int
+	// MaxRunning caps running jobs across all instances; 0 means no cap.
+	MaxRunning int
+	// MaxAttempts is how often a job abandoned by a crashed worker is retried.
+	MaxAttempts  int
+	PollInterval time.Duration
+	// StaleAfter is how long a running job may go without a heartbeat before
+	// it is considered abandoned.
+	StaleAfter time.Duration
+}
+
+func loadScanQueueConfig() scanQueueConfig {
+	cfg := scanQueueConfig{
+		Workers:      getEnvInt("SCAN_WORKERS", 2),
+		MaxRunning:   getEnvInt("SCAN_MAX_RUNNING", 0),
+		MaxAttempts:  getEnvInt("SCAN_MAX_ATTEMPTS", 3),
+		PollInterval: getEnvDuration("SCAN_QUEUE_POLL_INTERVAL", 5*time.Second),
+		StaleAfter:   getEnvDuration("SCAN_JOB_STALE_AFTER", 2*time.Minute),
+	}
+	if cfg.Workers < 1 {
+		cfg.Workers = 1
+	}
+	if cfg.MaxAttempts < 1 {
+		cfg.MaxAttempts = 1
+	}
+	return cfg
+}
+
+// scanQueueWakeup lets enqueueScan nudge an idle local worker instead of
+// waiting for the next poll.
+var scanQueueWakeup = make(chan struct{}, 1)
+
+// enqueueScan adds a scan to the durable job queue.
+func enqueueScan(ctx context.Context, job scanJob) error {
+	_, err := dbPool.Exec(ctx, `
+        INSERT INTO scan_jobs (scan_id, repo_url, sonar_project_key, status)
+        VALUES ($1, $2, $3, $4)`,
+		job.ScanID, job.RepoURL, job.SonarProjectKey, jobStatusQueued)
+	if err != nil {
+		return fmt.Errorf("failed to enqueue scan %s: %w", job.ScanID, err)
+	}
+	select {
+	case scanQueueWakeup <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// startScanWorkers launches the worker pool and the janitor that requeues
+// jobs abandoned by crashed backends.
+func startScanWorkers(cfg scanQueueConfig) {
+	hostname, _ := os.Hostname()
+	instanceID := fmt.Sprintf("%s-%d", hostname, os.Getpid())
+
+	go func() {
+		for {
+			requeueStaleScanJobs(context.Background(), cfg)
+			deleteFinishedScanUploads(context.Background())
+			time.Sleep(cfg.StaleAfter / 2)
+		}
+	}()
+
+	for i := 0; i < cfg.Workers; i++ {
+		go scanWorker(fmt.Sprintf("%s-w%d", instanceID, i), cfg)
+	}
+	log.Printf("Started %d scan workers (max running across instances: %d)", cfg.Workers, cfg.MaxRunning)
+}
+
+func scanWorker(workerID string, cfg scanQueueConfig) {
+	for {
+		job, err := claimScanJob(context.Background(), workerID, cfg.MaxRunning)
+		if err != nil {
+			log.Printf("Scan worker %s: failed to claim job: %v", workerID, err)
+		}
+		if job == nil {
+			select {
+			case <-scanQueueWakeup:
+			case <-time.After(cfg.PollInterval):
+			}
+			continue
+		}
+		processScanJob(workerID, *job, cfg)
+	}
+}
+
+// claimScanJob locks the oldest queued job and marks it running. It returns
+// nil when the queue is empty or the global running cap has been reached.
+func claimScanJob(ctx context.Context, workerID string, maxRunning int) (*scanJob, error) {
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	if maxRunning > 0 {
+		if _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock($1)", scanQueueLockKey); err != nil {
+			return nil, err
+		}
+		var running int
+		if err := tx.QueryRow(ctx, "SELECT COUNT(*) FROM scan_jobs WHERE status = $1", jobStatusRunning).Scan(&running); err != nil {
+			return nil, err
+		}
+		if running >= maxRunning {
+			return nil, nil
+		}
+	}
+
+	var job scanJob
+	err = tx.QueryRow(ctx, `
+        UPDATE scan_jobs j
+        SET status = $1, phase = NULL, worker_id = $2, attempts = j.attempts + 1,
+            started_at = NOW(), heartbeat_at = NOW()
+        FROM scans s
+        WHERE s.id = j.scan_id AND j.id = (
+            SELECT id FROM scan_jobs
+            WHERE status = $3
+            ORDER BY created_at
+            FOR UPDATE SKIP LOCKED
+            LIMIT 1
+        )
+        RETURNING j.id, j.scan_id, s.project_id, s.user_id, j.repo_url, COALESCE(s.requested_ref, ''), COALESCE(s.requested_commit, ''), s.force_rerun, j.sonar_project_key, j.attempts`,
+		jobStatusRunning, workerID, jobStatusQueued,
+	).Scan(&job.ID, &job.ScanID, &job.ProjectID, &job.UserID, &job.RepoURL, &job.Ref, &job.Commit, &job.Force, &job.SonarProjectKey, &job.Attempts)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(ctx, "UPDATE scans SET status = $1 WHERE id = $2 AND status = $3",
+		scanStatusRunning, job.ScanID, scanStatusQueued)
+	if err != nil {
+		return nil, err
+	}
+	return &job, tx.Commit(ctx)
+}
+
+// requeueStaleScanJobs puts running jobs whose worker stopped sending
+// heartbeats back in the queue, fails them once they ran out of attempts, or
+// cancels them if a cancel was requested. The scan follows the job, whose new
+// status is also a valid scan status.
+func requeueStaleScanJobs(ctx context.Context, cfg scanQueueConfig) {
+	tag, err := dbPool.Exec(ctx, `
+        WITH stale AS (
+            SELECT id, phase FROM scan_jobs
+            WHERE status = $4 AND heartbeat_at < NOW() - make_interval(secs => $5)
+            FOR UPDATE SKIP LOCKED
+        ), requeued AS (
+            UPDATE scan_jobs j
+            SET status = CASE WHEN j.cancel_requested THEN $6 WHEN j.attempts >= $1 THEN $2 ELSE $3 END,
+                finished_at = CASE WHEN j.cancel_requested OR j.attempts >= $1 THEN NOW() ELSE NULL END,
+                worker_id = NULL, phase = NULL,
+                last_error = 'Worker stopped responding while the scan was running.'
+            FROM stale
+            WHERE j.id = stale.id
+            RETURNING j.scan_id, j.status, stale.phase
+        )
+        UPDATE scans s
+        SET status = r.status,
+            finished_at = CASE WHEN r.status = $3 THEN NULL ELSE NOW() END,
+            failure_reasons = CASE WHEN r.status = $2 THEN jsonb_build_array(jsonb_build_object(
+                'phase', COALESCE(r.phase, $7),
+                'reason', 'Worker stopped responding while the scan was running.'))
+                ELSE '[]'::jsonb END
+        FROM requeued r
+        WHERE s.id = r.scan_id AND s.status = $4`,
+		cfg.MaxAttempts, jobStatusFailed, jobStatusQueued, jobStatusRunning, cfg.StaleAfter.Seconds(),
+		jobStatusCancelled, scanPhaseCloning)
+	if err != nil {
+		log.Printf("Failed to requeue stale scan jobs: %v", err)
+		return
+	}
+	if tag.RowsAffected() > 0 {
+		log.Printf("Recovered %d stale scan jobs", tag.RowsAffected())
+	}
+}
+
+// runningScans holds the cancel functions of the scans this instance is
+// currently processing, keyed by scan ID.
+var runningScans = struct {
+	sync.Mutex
+	cancels map[string]context.CancelFunc
+}{cancels: make(map[string]context.CancelFunc)}
+
+// cancelLocalScan cancels a scan if it is running on this instance. Scans
+// running elsewhere notice the cancel_requested flag on their next heartbeat.
+func cancelLocalScan(scanID string) bool {
+	runningScans.Lock()
+	defer runningScans.Unlock()
+
+	cancel, ok := runningScans.cancels[scanID]
+	if ok {
+		cancel()
+	}
+	return ok
+}
+
+func processScanJob(workerID string, job scanJob, cfg scanQueueConfig) {
+	ctx := context.Background()
+	runStartedAt := time.Now()
+	log.Printf("Worker %s picked up scan %s (attempt %d)", workerID, job.ScanID, job.Attempts)
+
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	runningScans.Lock()
+	runningScans.cancels[job.ScanID] = cancel
+	runningScans.Unlock()
+	defer func() {
+		runningScans.Lock()
+		delete(runningScans.cancels, job.ScanID)
+		runningScans.Unlock()
+	}()
+
+	logs := openScanLogHub(job.ScanID)
+	finalStatus := scanStatusFailed
+	defer func() {
+		logs.close(finalStatus)
+		lines, truncated := logs.transcriptLines()
+		saveScanLog(ctx, job.ScanID, lines, truncated)
+	}()
+
+	stopHeartbeat := make(chan struct{})
+	defer close(stopHeartbeat)
+	go func() {
+		ticker := time.NewTicker(cfg.StaleAfter / 4)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopHeartbeat:
+				return
+			case <-ticker.C:
+				var cancelRequested bool
+				err := dbPool.QueryRow(ctx, `
+                    UPDATE scan_jobs SET heartbeat_at = NOW() WHERE id = $1 AND worker_id = $2
+                    RETURNING cancel_requested`, job.ID, workerID).Scan(&cancelRequested)
+				if err != nil {
+					log.Printf("Failed to send heartbeat for scan job %s: %v", job.ID, err)
+				} else if cancelRequested {
+					cancel()
+				}
+			}
+		}
+	}()
+
+	var phaseMu sync.Mutex
+	currentPhase := scanPhaseCloning
+	setPhase := func(phase string) {
+		phaseMu.Lock()
+		currentPhase = phase
+		phaseMu.Unlock()
+		logs.phase(phase)
+		if _, err := dbPool.Exec(ctx, "UPDATE scan_jobs SET phase = $1 WHERE id = $2", phase, job.ID); err != nil {
+			log.Printf("Failed to update phase of scan job %s: %v", job.ID, err)
+		}
+	}
+	setPhase(scanPhaseCloning)
+
+	// A retried job may have been interrupted half-way through ingestion.
+	clearScanResults(ctx, job.ScanID)
+
+	results, err := runScanJob(runCtx, job, setPhase, logs)
+	if runCtx.Err() != nil {
+		log.Printf("Scan %s was cancelled", job.ScanID)
+		logs.info("Scan cancelled.")
+		finalStatus = scanStatusCancelled
+		finishScanJob(ctx, job.ID, jobStatusCancelled, "")
+		finishScan(ctx, job.ScanID, scanStatusCancelled, runStartedAt, nil)
+		return
+	}
+	if err != nil {
+		log.Printf("Scan failed for %s: %v", job.RepoURL, err)
+		phaseMu.Lock()
+		failedPhase := currentPhase
+		phaseMu.Unlock()
+		logs.info("Scan failed: " + err.Error())
+		finishScanJob(ctx, job.ID, jobStatusFailed, err.Error())
+		finishScan(ctx, job.ScanID, scanStatusFailed, runStartedAt, []scanFailure{{Phase: failedPhase, Reason: failureReason(err)}})
+		return
+	}
+
+	if results.ReusedFrom != "" {
+		finalStatus = scanStatusSucceeded
+		finishScanJob(ctx, job.ID, jobStatusCompleted, "")
+		finishScan(ctx, job.ScanID, finalStatus, runStartedAt, nil)
+		return
+	}
+
+	// Once the tool outputs are in, a late cancel request is ignored and the
+	// results are stored as usual.
+	setPhase(scanPhaseIngesting)
+	ingestFailures, storedAny := storeScanResults(ctx, job.ScanID, results)
+	failures := append(results.Failures, ingestFailures...)
+	for _, failure := range failures {
+		logs.info(fmt.Sprintf("%s: %s", failure.Phase, failure.Reason))
+	}
+	finalStatus = scanOutcome(failures, storedAny)
+	finishScanJob(ctx, job.ID, jobStatusCompleted, "")
+	finishScan(ctx, job.ScanID, finalStatus, runStartedAt, failures)
+}
+
+// runScanJob loads the project's resource limits, runs the analysis
+// container and collects the report of every registered analyzer. A commit
+// analyzed before gets the results of that scan copied instead.
+func runScanJob(ctx context.Context, job scanJob, setPhase func(phase string), logs *scanLogHub) (analysisResults, error) {
+	limits, err := loadScanLimits(ctx, job.ProjectID)
+	if err != nil {
+		return analysisResults{}, err
+	}
+
+	outputDir, err := os.MkdirTemp("", "scan-")
+	if err != nil {
+		return analysisResults{}, fmt.Errorf("failed to create temp dir: %w", err)
+	}
+	defer os.RemoveAll(outputDir)
+
+	sources, err := loadProjectSources(ctx, job.ProjectID)
+	if err != nil {
+		return analysisResults{}, err
+	}
+	detektConfig, err := loadProjectDetektConfig(ctx, job.ProjectID)
+	if err != nil {
+		return analysisResults{}, err
+	}
+	in := analysisInputs{OutputDir: outputDir, Sources: sources, Detekt: detektConfig}
+	if in.DetektConfigDir, err = writeDetektConfigDir(detektConfig); err != nil {
+		return analysisResults{}, err
+	}
+	if in.DetektConfigDir != "" {
+		defer os.RemoveAll(in.DetektConfigDir)
+	}
+	switch {
+	case isUploadURL(job.RepoURL):
+		// Uploaded sources take the place of the clone.
+		setPhase(scanPhaseCloning)
+		if in.SourceDir, err = os.MkdirTemp("", "scan-source-"); err != nil {
+			return analysisResults{}, fmt.Errorf("failed to create source dir: %w", err)
+		}
+		defer os.RemoveAll(in.SourceDir)
+		if err := prepareUploadedSource(ctx, job.ScanID, in.SourceDir); err != nil {
+			return analysisResults{}, err
+		}
+	case isLocalRepoURL(job.RepoURL):
+		// Checked again as the allowed roots may have changed since the scan
+		// was requested.
+		if in.LocalRepoDir, err = resolveLocalRepo(job.RepoURL); err != nil {
+			return analysisResults{}, err
+		}
+	default:
+		// Checked again as the policy, or what the host resolves to, may
+		// have changed since the scan was requested.
+		if in.Repo, err = checkRepoURL(ctx, job.RepoURL); err != nil {
+			return analysisResults{}, fmt.Errorf("repository URL not allowed: %w", err)
+		}
+		cred, err := loadProjectCredential(ctx, job.ProjectID)
+		if err != nil {
+			return analysisResults{}, err
+		}
+		if cred != nil {
+			if in.Secrets, err = writeCloneSecrets(cred); err != nil {
+				return analysisResults{}, err
+			}
+			defer in.Secrets.remove()
+		}
+	}
+
+	// A commit analyzed before with the same configuration isn't analyzed
+	// again unless asked to. Failing to tell only costs the analysis.
+	if !job.Force {
+		fromScanID, err := reuseUnchangedScan(ctx, job, in, setPhase, logs)
+		if err != nil {
+			log.Printf("Could not check whether scan %s was analyzed before: %v", job.ScanID, err)
+		} else if fromScanID != "" {
+			return analysisResults{ReusedFrom: fromScanID}, nil
+		}
+	}
+
+	image, err := runAnalysis(ctx, job, limits, in, setPhase, logs)
+	// The commit and the toolchain are known as soon as the clone is done,
+	// so they are recorded even when a later step fails.
+	toolVersions, infoErr := readToolVersions(outputDir)
+	if infoErr != nil && !os.IsNotExist(infoErr) {
+		log.Printf("Failed to read tool versions of scan %s: %v", job.ScanID, infoErr)
+	}
+	if image.Ref != "" {
+		fingerprint := scanConfigFingerprint(image, sources, detektConfig)
+		if recordErr := recordToolchain(context.Background(), job.ScanID, image, toolVersions, fingerprint); recordErr != nil {
+			log.Print(recordErr)
+		}
+	}
+	if provenance, infoErr := readGitInfo(outputDir); infoErr == nil {
+		if recordErr := recordScanProvenance(context.Background(), job.ScanID, provenance); recordErr != nil {
+			log.Print(recordErr)
+		}
+	}
+	if usage, infoErr := readDetektConfigUsage(outputDir, detektConfig); infoErr == nil {
+		if recordErr := recordDetektConfigUsage(context.Background(), job.ScanID, usage); recordErr != nil {
+			log.Print(recordErr)
+		}
+	}
+	if err != nil {
+		return analysisResults{}, err
+	}
+	return runAnalyzers(ctx, &analysisWorkspace{
+		ScanID:          job.ScanID,
+		SonarProjectKey: job.SonarProjectKey,
+		OutputDir:       outputDir,
+		RepoDir:         scanRunner.repoDir(outputDir),
+		ToolVersions:    toolVersions,
+		SetPhase:        setPhase,
+		Logs:            logs,
+	})
+}
+
+func finishScan(ctx context.Context, scanID, status string, runStartedAt time.Time, failures []scanFailure) {
+	moved, err := transitionScanStatus(ctx, scanID, status, runStartedAt, failures)
+	if err != nil {
+		log.Printf("Failed to record outcome of scan %s: %v", scanID, err)
+		return
+	}
+	if !moved {
+		log.Printf("Scan %s was no longer running; not marking it %s", scanID, status)
+	}
+}
+
+func finishScanJob(ctx context.Context, jobID, status, errMsg string) {
+	_, err := dbPool.Exec(ctx, `
+        UPDATE scan_jobs SET status = $1, last_error = NULLIF($2, ''), phase = NULL, finished_at = NOW()
+        WHERE id = $3`,
+		status, errMsg, jobID)
+	if err != nil {
+		log.Printf("Failed to mark scan job %s as %s: %v", jobID, status, err)
+	}
+}
+
+func clearScanResults(ctx context.Context, scanID string) {
+	for _, table := range []string{"detekt_results", "sonarqube_results", "analyzer_results", "scan_findings"} {
+		if _, err := dbPool.Exec(ctx, "DELETE FROM "+table+" WHERE scan_id = $1", scanID); err != nil {
+			log.Printf("Failed to clear %s for scan %s: %v", table, scanID, err)
+		}
+	}
+}
+
+func scanStatusHandler(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	scanId := c.Param("scanId")
+
+	var status string
+	var ref *string
+	var failureReasons []scanFailure
+	var finishedAt *time.Time
+	var durationMs *int64
+	var phase, lastError, reusedFrom *string
+	var attempts, queuePosition *int
+	err := dbPool.QueryRow(context.Background(), `
+        SELECT
+            s.status, s.requested_ref, s.failure_reasons, s.finished_at, s.duration_ms, s.reused_from_scan_id,
+            j.phase, j.last_error, j.attempts,
+            CASE WHEN j.status = $3 THEN
+                (SELECT COUNT(*) FROM scan_jobs q WHERE q.status = $3 AND q.created_at < j.created_at)::int
+            END
+        FROM scans s
+        LEFT JOIN scan_jobs j ON j.scan_id = s.id
+        WHERE s.id = $1 AND s.user_id = $2`,
+		scanId, userID.(string), jobStatusQueued,
+	).Scan(&status, &ref, &failureReasons, &finishedAt, &durationMs, &reusedFrom, &phase, &lastError, &attempts, &queuePosition)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Scan not found"})
+		return
+	}
+
+	response := gin.H{
+		"scanId":         scanId,
+		"status":         status,
+		"ref":            ref,
+		"failureReasons": failureReasons,
+		"finishedAt":     finishedAt,
+		"durationMs":     durationMs,
+		// The scan whose results were reused because the commit was
+		// analyzed before; null when the analysis ran.
+		"reusedFromScanId": reusedFrom,
+	}
+	if attempts != nil {
+		response["attempts"] = *attempts
+	}
+	if phase != nil && status == scanStatusRunning {
+		response["phase"] = *phase
+	}
+	if lastError != nil {
+		response["error"] = *lastError
+	}
+	if queuePosition != nil {
+		response["queuePosition"] = *queuePosition
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func cancelScanHandler(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	scanId := c.Param("scanId")
+	ctx := context.Background()
+
+	var status string
+	err := dbPool.QueryRow(ctx, "SELECT status FROM scans WHERE id = $1 AND user_id = $2", scanId, userID.(string)).Scan(&status)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Scan not found"})
+		return
+	}
+
+	// A queued job is cancelled outright, as long as no worker claims it first.
+	tag, err := dbPool.Exec(ctx, `
+        UPDATE scan_jobs SET status = $1, finished_at = NOW()
+        WHERE scan_id = $2 AND status = $3`,
+		jobStatusCancelled, scanId, jobStatusQueued)
+	if err != nil {
+		log.Printf("Failed to cancel queued scan %s: %v", scanId, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not cancel scan"})
+		return
+	}
+	if tag.RowsAffected() > 0 {
+		finishScan(ctx, scanId, scanStatusCancelled, time.Time{}, nil)
+		c.JSON(http.StatusOK, gin.H{"scanId": scanId, "status": scanStatusCancelled})
+		return
+	}
+
+	// A running job is flagged; the worker stops the container, cleans up and
+	// marks the scan cancelled.
+	tag, err = dbPool.Exec(ctx, `
+        UPDATE scan_jobs SET cancel_requested = TRUE
+        WHERE scan_id = $1 AND status = $2`,
+		scanId, jobStatusRunning)
+	if err != nil {
+		log.Printf("Failed to request cancellation of scan %s: %v", scanId, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not cancel scan"})
+		return
+	}
+	if tag.RowsAffected() > 0 {
+		cancelLocalScan(scanId)
+		c.JSON(http.StatusAccepted, gin.H{"scanId": scanId, "status": scanStatusRunning, "cancelRequested": true})
+		return
+	}
+
+	c.JSON(http.StatusConflict, gin.H{"error": "Scan has already finished", "status": status})
+}
+
+// logLineWriter splits a container log stream into lines and hands each
+// complete line to onLine.
+type logLineWriter struct {
+	buf    bytes.Buffer
+	onLine func(line string)
+}
+
+func (w *logLineWriter) Write(p []byte) (int, error) {
+	w.buf.Write(p)
+	for {
+		line, err := w.buf.ReadString('\n')
+		if err != nil {
+			// Keep the incomplete line for the next write.
+			w.buf.Reset()
+			w.buf.WriteString(line)
+			break
+		}
+		w.onLine(strings.TrimRight(line, "\r\n"))
+	}
+	return len(p), nil
+}
+
+// phaseFromLogLine returns the phase announced by an analyze.sh marker line.
+func phaseFromLogLine(line string) (string, bool) {
+	if !strings.HasPrefix(line, phaseMarkerPrefix) {
+		return "", false
+	}
+	return strings.TrimSpace(strings.TrimPrefix(line, phaseMarkerPrefix)), true
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-units"
+	"github.com/gin-gonic/gin"
+)
+
+// scanLimits bounds the resources of one analysis container. A zero field
+// means no limit.
+type scanLimits struct {
+	MemoryBytes  int64
+	CPUs         float64
+	PidsLimit    int64
+	MaxRuntime   time.Duration
+	MaxDiskBytes int64 // size of the /data directory shared with the container
+}
+
+// resourceLimitError is returned when a scan is stopped for exceeding one of
+// its limits.
+type resourceLimitError struct {
+	Limit string
+}
+
+func (e *resourceLimitError) Error() string {
+	return "resource limit exceeded: " + e.Limit
+}
+
+// diskCheckInterval is how often the size of a running scan's /data
+// directory is measured.
+const diskCheckInterval = 5 * time.Second
+
+// minScanMemoryBytes is the least memory a project can limit its analysis
+// to. Below it the container is killed before the tools get going.
+const minScanMemoryBytes = 64 << 20
+
+// defaultScanLimits returns the deployment-wide limits. Each can be disabled
+// by setting its variable to 0.
+func defaultScanLimits() scanLimits {
+	return scanLimits{
+		MemoryBytes:  getEnvBytes("SCAN_MEMORY_LIMIT", 4<<30),
+		CPUs:         getEnvFloat("SCAN_CPUS", 2),
+		PidsLimit:    int64(getEnvInt("SCAN_PIDS_LIMIT", 1024)),
+		MaxRuntime:   getEnvDuration("SCAN_MAX_RUNTIME", 30*time.Minute),
+		MaxDiskBytes: getEnvBytes("SCAN_MAX_DISK", 10<<30),
+	}
+}
+
+// projectLimitOverrides are the per-project limits stored on the projects
+// table. Nil fields fall back to the deployment default.
+type projectLimitOverrides struct {
+	MemoryBytes  *int64
+	CPUs         *float64
+	PidsLimit    *int64
+	MaxRuntime   *time.Duration
+	MaxDiskBytes *int64
+}
+
+func loadProjectLimitOverrides(ctx context.Context, projectID string) (projectLimitOverrides, error) {
+	var o projectLimitOverrides
+	var runtimeSeconds *int64
+	err := dbPool.QueryRow(ctx, `
+        SELECT memory_limit_bytes, cpu_limit, pids_limit, max_runtime_seconds, max_disk_bytes
+        FROM projects WHERE id = $1`, projectID,
+	).Scan(&o.MemoryBytes, &o.CPUs, &o.PidsLimit, &runtimeSeconds, &o.MaxDiskBytes)
+	if runtimeSeconds != nil {
+		d := time.Duration(*runtimeSeconds) * time.Second
+		o.MaxRuntime = &d
+	}
+	return o, err
+}
+
+// effectiveScanLimits applies a project's overrides to the deployment
+// defaults. Overrides may only tighten a limit the deployment sets.
+func effectiveScanLimits(defaults scanLimits, o projectLimitOverrides) scanLimits {
+	limits := defaults
+	if o.MemoryBytes != nil && (defaults.MemoryBytes == 0 || *o.MemoryBytes < defaults.MemoryBytes) {
+		limits.MemoryBytes = *o.MemoryBytes
+	}
+	if o.CPUs != nil && (defaults.CPUs == 0 || *o.CPUs < defaults.CPUs) {
+		limits.CPUs = *o.CPUs
+	}
+	if o.PidsLimit != nil && (defaults.PidsLimit == 0 || *o.PidsLimit < defaults.PidsLimit) {
+		limits.PidsLimit = *o.PidsLimit
+	}
+	if o.MaxRuntime != nil && (defaults.MaxRuntime == 0 || *o.MaxRuntime < defaults.MaxRuntime) {
+		limits.MaxRuntime = *o.MaxRuntime
+	}
+	if o.MaxDiskBytes != nil && (defaults.MaxDiskBytes == 0 || *o.MaxDiskBytes < defaults.MaxDiskBytes) {
+		limits.MaxDiskBytes = *o.MaxDiskBytes
+	}
+	return limits
+}
+
+func loadScanLimits(ctx context.Context, projectID string) (scanLimits, error) {
+	overrides, err := loadProjectLimitOverrides(ctx, projectID)
+	if err != nil {
+		return scanLimits{}, fmt.Errorf("failed to load limits of project %s: %w", projectID, err)
+	}
+	return effectiveScanLimits(defaultScanLimits(), overrides), nil
+}
+
+// containerResources converts the limits Docker enforces itself. Swap is
+// disabled so the memory limit is a hard one.
+func (l scanLimits) containerResources() container.Resources {
+	var resources container.Resources
+	if l.MemoryBytes > 0 {
+		resources.Memory = l.MemoryBytes
+		resources.MemorySwap = l.MemoryBytes
+	}
+	if l.CPUs > 0 {
+		resources.NanoCPUs = int64(l.CPUs * 1e9)
+	}
+	if l.PidsLimit > 0 {
+		pids := l.PidsLimit
+		resources.PidsLimit = &pids
+	}
+	return resources
+}
+
+func (l scanLimits) toJSON() gin.H {
+	response := gin.H{"memory": nil, "cpus": nil, "pidsLimit": nil, "maxRuntime": nil, "maxDisk": nil}
+	if l.MemoryBytes > 0 {
+		response["memory"] = units.BytesSize(float64(l.MemoryBytes))
+	}
+	if l.CPUs > 0 {
+		response["cpus"] = l.CPUs
+	}
+	if l.PidsLimit > 0 {
+		response["pidsLimit"] = l.PidsLimit
+	}
+	if l.MaxRuntime > 0 {
+		response["maxRuntime"] = l.MaxRuntime.String()
+	}
+	if l.MaxDiskBytes > 0 {
+		response["maxDisk"] = units.BytesSize(float64(l.MaxDiskBytes))
+	}
+	return response
+}
+
+// watchDiskUsage measures dir every diskCheckInterval until ctx is done and
+// calls onExceeded once if it grows beyond maxBytes.
+func watchDiskUsage(ctx context.Context, dir string, maxBytes int64, onExceeded func()) {
+	if maxBytes <= 0 {
+		return
+	}
+	ticker := time.NewTicker(diskCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if dirSize(dir) > maxBytes {
+				onExceeded()
+				return
+			}
+		}
+	}
+}
+
+func dirSize(dir string) int64 {
+	var total int64
+	filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.Type().IsRegular() {
+			if info, err := d.Info(); err == nil {
+				total += info.Size()
+			}
+		}
+		return nil
+	})
+	return total
+}
+
+func getProjectLimitsHandler(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	projectID := c.Param("id")
+	ctx := context.Background()
+
+	var exists bool
+	err := dbPool.QueryRow(ctx, "SELECT EXISTS(SELECT 1 FROM projects WHERE id=$1 AND user_id=$2)", projectID, userID.(string)).Scan(&exists)
+	if err != nil || !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+	overrides, err := loadProjectLimitOverrides(ctx, projectID)
+	if err != nil {
+		log.Printf("Failed to load limits of project %s: %v", projectID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch project limits"})
+		return
+	}
+	defaults := defaultScanLimits()
+	c.JSON(http.StatusOK, gin.H{
+		"defaults":  defaults.toJSON(),
+		"effective": effectiveScanLimits(defaults, overrides).toJSON(),
+	})
+}
+
+// updateProjectLimitsHandler replaces a project's limit overrides. Omitted or
+// null fields go back to the deployment default; values above a limit the
+// deployment sets are rejected.
+func updateProjectLimitsHandler(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	projectID := c.Param("id")
+
+	var req struct {
+		Memory     *string  `json:"memory"`
+		CPUs       *float64 `json:"cpus"`
+		PidsLimit  *int64   `json:"pidsLimit"`
+		MaxRuntime *string  `json:"maxRuntime"`
+		MaxDisk    *string  `json:"maxDisk"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data."})
+		return
+	}
+
+	defaults := defaultScanLimits()
+	var memoryBytes, maxDiskBytes, runtimeSeconds *int64
+	if req.Memory != nil {
+		v, err := units.RAMInBytes(*req.Memory)
+		if err != nil || v <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "memory must be a size such as \"2g\""})
+			return
+		}
+		if v < minScanMemoryBytes {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "memory must be at least " + units.BytesSize(minScanMemoryBytes)})
+			return
+		}
+		memoryBytes = &v
+	}
+	if req.MaxDisk != nil {
+		v, err := units.RAMInBytes(*req.MaxDisk)
+		if err != nil || v <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "maxDisk must be a size such as \"5g\""})
+			return
+		}
+		maxDiskBytes = &v
+	}
+	if req.MaxRuntime != nil {
+		d, err := time.ParseDuration(*req.MaxRuntime)
+		if err != nil || d < time.Second {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "maxRuntime must be a duration such as \"20m\""})
+			return
+		}
+		secs := int64(d / time.Second)
+		runtimeSeconds = &secs
+	}
+	if (req.CPUs != nil && *req.CPUs <= 0) || (req.PidsLimit != nil && *req.PidsLimit <= 0) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "cpus and pidsLimit must be positive"})
+		return
+	}
+
+	exceeds := func(value *int64, limit int64) bool { return value != nil && limit > 0 && *value > limit }
+	if exceeds(memoryBytes, defaults.MemoryBytes) || exceeds(maxDiskBytes, defaults.MaxDiskBytes) ||
+		exceeds(req.PidsLimit, defaults.PidsLimit) ||
+		exceeds(runtimeSeconds, int64(defaults.MaxRuntime/time.Second)) ||
+		(req.CPUs != nil && defaults.CPUs > 0 && *req.CPUs > defaults.CPUs) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Project limits cannot exceed the deployment limits", "defaults": defaults.toJSON()})
+		return
+	}
+
+	tag, err := dbPool.Exec(context.Background(), `
+        UPDATE projects
+        SET memory_limit_bytes = $1, cpu_limit = $2, pids_limit = $3, max_runtime_seconds = $4, max_disk_bytes = $5
+        WHERE id = $6 AND user_id = $7`,
+		memoryBytes, req.CPUs, req.PidsLimit, runtimeSeconds, maxDiskBytes, projectID, userID.(string))
+	if err != nil {
+		log.Printf("Failed to update limits of project %s: %v", projectID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not update project limits"})
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+	getProjectLimitsHandler(c)
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultScanLogMaxBytes caps the uncompressed size of a stored scan log.
+// Override with SCAN_LOG_MAX_BYTES.
+const defaultScanLogMaxBytes = 5 << 20
+
+// scanLogRecorder keeps the transcript of a scan within a byte budget. Half
+// of the budget holds the start of the log and half its most recent lines,
+// since failures usually show up at one end or the other.
+type scanLogRecorder struct {
+	halfBudget int
+	head       []string
+	headBytes  int
+	tail       []string
+	tailBytes  int
+	omitted    int
+}
+
+func newScanLogRecorder(maxBytes int) *scanLogRecorder {
+	return &scanLogRecorder{halfBudget: maxBytes / 2}
+}
+
+func (r *scanLogRecorder) add(line string) {
+	// A line is cut to half the budget, so that no single line takes the
+	// log over it.
+	line = truncateLogLine(line, r.halfBudget-1)
+	size := len(line) + 1
+	if len(r.tail) == 0 && r.headBytes+size <= r.halfBudget {
+		r.head = append(r.head, line)
+		r.headBytes += size
+		return
+	}
+	r.tail = append(r.tail, line)
+	r.tailBytes += size
+	for r.tailBytes > r.halfBudget && len(r.tail) > 1 {
+		r.tailBytes -= len(r.tail[0]) + 1
+		r.tail = r.tail[1:]
+		r.omitted++
+	}
+}
+
+const truncatedLineMarker = " [line truncated]"
+
+// truncateLogLine cuts a line to at most limit bytes, on a rune boundary, and
+// marks it as cut if there is room for the marker.
+func truncateLogLine(line string, limit int) string {
+	if len(line) <= limit {
+		return line
+	}
+	if limit < 0 {
+		limit = 0
+	}
+	marker := ""
+	if limit >= 2*len(truncatedLineMarker) {
+		marker = truncatedLineMarker
+	}
+	cut := limit - len(marker)
+	for cut > 0 && !utf8.RuneStart(line[cut]) {
+		cut--
+	}
+	return line[:cut] + marker
+}
+
+func (r *scanLogRecorder) truncated() bool {
+	return r.omitted > 0
+}
+
+func (r *scanLogRecorder) lines() []string {
+	lines := make([]string, 0, len(r.head)+len(r.tail)+1)
+	lines = append(lines, r.head...)
+	if r.omitted > 0 {
+		lines = append(lines, fmt.Sprintf("... %d lines omitted ...", r.omitted))
+	}
+	return append(lines, r.tail...)
+}
+
+// formatScanLogEvent renders an event as a line of the stored transcript.
+func formatScanLogEvent(event scanLogEvent) string {
+	ts := event.Time.Format("15:04:05.000")
+	switch event.Type {
+	case scanEventLog:
+		return fmt.Sprintf("%s [%s] %s", ts, event.Stream, event.Line)
+	case scanEventPhase:
+		return fmt.Sprintf("%s [phase] %s: %s", ts, event.Phase, event.Message)
+	case scanEventEnd:
+		return fmt.Sprintf("%s [dp] Scan finished: %s", ts, event.Status)
+	default:
+		return fmt.Sprintf("%s [dp] %s", ts, event.Message)
+	}
+}
+
+// sonarTokenArg matches the token on a sonar-scanner command line, which
+// analyze.sh used to echo into the log.
+var sonarTokenArg = regexp.MustCompile(`-Dsonar\.(token|login)=\S+`)
+
+// redactScanLogLine masks the SonarQube token in a line of a transcript.
+func redactScanLogLine(line string) string {
+	line = redactToken(line, os.Getenv("SONAR_LOGIN_TOKEN"))
+	return sonarTokenArg.ReplaceAllString(line, "-Dsonar.$1=[REDACTED]")
+}
+
+// compressScanLog redacts and gzips the lines of a transcript. It returns
+// the uncompressed size.
+func compressScanLog(lines []string) ([]byte, int, error) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	size := 0
+	for _, line := range lines {
+		n, _ := io.WriteString(gz, redactScanLogLine(line)+"\n")
+		size += n
+	}
+	if err := gz.Close(); err != nil {
+		return nil, 0, err
+	}
+	return buf.Bytes(), size, nil
+}
+
+// saveScanLog compresses and stores the transcript of a finished scan,
+// replacing the log of an earlier attempt.
+func saveScanLog(ctx context.Context, scanID string, lines []string, truncated bool) {
+	compressed, size, err := compressScanLog(lines)
+	if err != nil {
+		log.Printf("Failed to compress log of scan %s: %v", scanID, err)
+		return
+	}
+
+	_, err = dbPool.Exec(ctx, `
+        INSERT INTO scan_logs (scan_id, log_gzip, size_bytes, line_count, truncated)
+        VALUES ($1, $2, $3, $4, $5)
+        ON CONFLICT (scan_id) DO UPDATE
+        SET log_gzip = EXCLUDED.log_gzip, size_bytes = EXCLUDED.size_bytes,
+            line_count = EXCLUDED.line_count, truncated = EXCLUDED.truncated, created_at = NOW()`,
+		scanID, compressed, size, len(lines), truncated)
+	if err != nil {
+		log.Printf("Failed to store log of scan %s: %v", scanID, err)
+	}
+}
+
+// redactStoredScanLogs masks the SonarQube token in logs stored before it
+// was redacted on the way in. Logs that are already clean are left alone.
+func redactStoredScanLogs(ctx context.Context) {
+	rows, err := dbPool.Query(ctx, "SELECT scan_id FROM scan_logs")
+	if err != nil {
+		log.Printf("Failed to list stored scan logs: %v", err)
+		return
+	}
+	var scanIDs []string
+	for rows.Next() {
+		var scanID string
+		if err := rows.Scan(&scanID); err == nil {
+			scanIDs = append(scanIDs, scanID)
+		}
+	}
+	rows.Close()
+
+	redacted := 0
+	for _, scanID := range scanIDs {
+		var compressed []byte
+		if err := dbPool.QueryRow(ctx, "SELECT log_gzip FROM scan_logs WHERE scan_id = $1", scanID).Scan(&compressed); err != nil {
+			continue
+		}
+		lines, err := loadScanLog(compressed)
+		if err != nil {
+			log.Printf("Failed to decompress log of scan %s: %v", scanID, err)
+			continue
+		}
+		if !slices.ContainsFunc(lines, func(line string) bool { return redactScanLogLine(line) != line }) {
+			continue
+		}
+		compressed, size, err := compressScanLog(lines)
+		if err != nil {
+			log.Printf("Failed to compress log of scan %s: %v", scanID, err)
+			continue
+		}
+		if _, err := dbPool.Exec(ctx, "UPDATE scan_logs SET log_gzip = $2, size_bytes = $3 WHERE scan_id = $1", scanID, compressed, size); err != nil {
+			log.Printf("Failed to store redacted log of scan %s: %v", scanID, err)
+			continue
+		}
+		redacted++
+	}
+	if redacted > 0 {
+		log.Printf("Redacted the SonarQube token in %d stored scan logs", redacted)
+	}
+}
+
+func loadScanLog(compressed []byte) ([]string, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+	data, err := io.ReadAll(gz)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n"), nil
+}
+
+// getScanLogHandler returns the stored log of a scan as plain text. For a
+// scan still running on this instance it returns the log so far. The optional
+// "tail" query parameter returns only the last N lines; "offset" and "limit"
+// select a range of lines instead.
+func getScanLogHandler(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	scanId := c.Param("scanId")
+
+	var lines []string
+	var truncated bool
+	var compressed []byte
+	err := dbPool.QueryRow(context.Background(),
+		`SELECT sl.log_gzip, sl.truncated
+         FROM scan_logs sl
+         INNER JOIN scans s ON sl.scan_id = s.id
+         WHERE sl.scan_id = $1 AND s.user_id = $2`,
+		scanId, userID.(string)).Scan(&compressed, &truncated)
+	if err == nil {
+		lines, err = loadScanLog(compressed)
+		if err != nil {
+			log.Printf("Failed to decompress log of scan %s: %v", scanId, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not read scan log"})
+			return
+		}
+	} else {
+		var exists bool
+		dbPool.QueryRow(context.Background(), "SELECT EXISTS(SELECT 1 FROM scans WHERE id = $1 AND user_id = $2)", scanId, userID.(string)).Scan(&exists)
+		hub := getScanLogHub(scanId)
+		if !exists || hub == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Scan log not found"})
+			return
+		}
+		lines, truncated = hub.transcriptLines()
+	}
+	for i := range lines {
+		lines[i] = redactScanLogLine(lines[i])
+	}
+
+	total := len(lines)
+	if tailParam := c.Query("tail"); tailParam != "" {
+		tail, err := strconv.Atoi(tailParam)
+		if err != nil || tail < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "tail must be a non-negative integer"})
+			return
+		}
+		if tail < len(lines) {
+			lines = lines[len(lines)-tail:]
+		}
+	} else if c.Query("offset") != "" || c.Query("limit") != "" {
+		offset, err1 := strconv.Atoi(c.DefaultQuery("offset", "0"))
+		limit, err2 := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(total)))
+		if err1 != nil || err2 != nil || offset < 0 || limit < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "offset and limit must be non-negative integers"})
+			return
+		}
+		if offset > total {
+			offset = total
+		}
+		// Clamped before adding, as offset+limit may overflow.
+		if limit > total-offset {
+			limit = total - offset
+		}
+		lines = lines[offset : offset+limit]
+	}
+
+	c.Header("X-Log-Total-Lines", strconv.Itoa(total))
+	c.Header("X-Log-Truncated", strconv.FormatBool(truncated))
+	c.Data(http.StatusOK, "text/plain; charset=utf-8", []byte(strings.Join(lines, "\n")+"\n"))
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestScanLogRecorderCutsLongLines(t *testing.T) {
+	r := newScanLogRecorder(100)
+	r.add("start")
+	r.add(strings.Repeat("x", 1000))
+	r.add("end")
+
+	size := 0
+	for _, line := range r.lines() {
+		size += len(line) + 1
+	}
+	if size > 100+len("... 1 lines omitted ...")+1 {
+		t.Errorf("recorded %d bytes, want at most the budget of 100 and the omission note", size)
+	}
+	lines := r.lines()
+	if lines[0] != "start" || lines[len(lines)-1] != "end" {
+		t.Errorf("lines = %q, want the first and last line kept", lines)
+	}
+
+	r = newScanLogRecorder(100)
+	r.add(strings.Repeat("x", 1000))
+	if got := r.lines()[0]; len(got) > 49 || !strings.HasSuffix(got, truncatedLineMarker) {
+		t.Errorf("long line recorded as %q, want it cut to 49 bytes and marked", got)
+	}
+}
+
+func TestTruncateLogLine(t *testing.T) {
+	tests := []struct {
+		line  string
+		limit int
+		want  string
+	}{
+		{"short", 10, "short"},
+		{"äöü", 3, "ä"},
+		{"abc", 0, ""},
+		{strings.Repeat("a", 50), 40, strings.Repeat("a", 40-len(truncatedLineMarker)) + truncatedLineMarker},
+	}
+	for _, tt := range tests {
+		if got := truncateLogLine(tt.line, tt.limit); got != tt.want {
+			t.Errorf("truncateLogLine(%q, %d) = %q, want %q", tt.line, tt.limit, got, tt.want)
+		}
+	}
+}
+
+func TestRedactScanLogLine(t *testing.T) {
+	t.Setenv("SONAR_LOGIN_TOKEN", "squ_secret")
+	tests := map[string]string{
+		"sonar-scanner -Dsonar.token=squ_old -Dsonar.sources=.": "sonar-scanner -Dsonar.token=[REDACTED] -Dsonar.sources=.",
+		"-Dsonar.login=abc":         "-Dsonar.login=[REDACTED]",
+		"Authorization: squ_secret": "Authorization: [REDACTED]",
+		"Analysis finished.":        "Analysis finished.",
+	}
+	for line, want := range tests {
+		if got := redactScanLogLine(line); got != want {
+			t.Errorf("redactScanLogLine(%q) = %q, want %q", line, got, want)
+		}
+	}
+}
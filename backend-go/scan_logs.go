@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Event types sent on GET /api/scan/:scanId/logs/stream.
+const (
+	scanEventLog    = "log"    // a line of container output
+	scanEventPhase  = "phase"  // the scan entered a new phase
+	scanEventInfo   = "info"   // a backend-side message about the scan
+	scanEventStatus = "status" // the scan's status, for scans running elsewhere
+	scanEventEnd    = "end"    // the scan finished; no more events follow
+)
+
+// scanLogBacklogSize is how many recent events a hub keeps so that clients
+// connecting mid-scan see some context before the live lines.
+const scanLogBacklogSize = 1000
+
+// scanLogSubscriberBuffer is the channel size per subscriber. Events for a
+// subscriber that falls this far behind are dropped rather than blocking the
+// container log copy.
+const scanLogSubscriberBuffer = 256
+
+var scanPhaseMessages = map[string]string{
+	scanPhaseCloning:         "Cloning repository",
+	scanPhaseDetekt:          "Running Detekt",
+	scanPhaseKtlint:          "Running ktlint",
+	scanPhaseSonarUpload:     "Running SonarScanner and uploading the analysis",
+	scanPhaseSonarProcessing: "Waiting for SonarQube to process the analysis",
+	scanPhaseIngesting:       "Storing results",
+}
+
+type scanLogEvent struct {
+	Type    string    `json:"type"`
+	Stream  string    `json:"stream,omitempty"` // stdout or stderr, for log events
+	Line    string    `json:"line,omitempty"`
+	Phase   string    `json:"phase,omitempty"`
+	Status  string    `json:"status,omitempty"`
+	Message string    `json:"message,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// scanLogHub fans the events of one running scan out to any number of SSE
+// subscribers. Hubs only exist on the instance running the scan.
+type scanLogHub struct {
+	scanID      string
+	mu          sync.Mutex
+	subscribers map[chan scanLogEvent]struct{}
+	backlog     []scanLogEvent
+	transcript  *scanLogRecorder
+	closed      bool
+}
+
+var scanLogHubs = struct {
+	sync.Mutex
+	hubs map[string]*scanLogHub
+}{hubs: make(map[string]*scanLogHub)}
+
+func openScanLogHub(scanID string) *scanLogHub {
+	hub := &scanLogHub{
+		scanID:      scanID,
+		subscribers: make(map[chan scanLogEvent]struct{}),
+		transcript:  newScanLogRecorder(getEnvInt("SCAN_LOG_MAX_BYTES", defaultScanLogMaxBytes)),
+	}
+	scanLogHubs.Lock()
+	scanLogHubs.hubs[scanID] = hub
+	scanLogHubs.Unlock()
+	return hub
+}
+
+func getScanLogHub(scanID string) *scanLogHub {
+	scanLogHubs.Lock()
+	defer scanLogHubs.Unlock()
+	return scanLogHubs.hubs[scanID]
+}
+
+func (h *scanLogHub) publish(event scanLogEvent) {
+	if h == nil {
+		return
+	}
+	event.Time = time.Now()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return
+	}
+	h.transcript.add(formatScanLogEvent(event))
+	h.backlog = append(h.backlog, event)
+	if len(h.backlog) > scanLogBacklogSize {
+		h.backlog = h.backlog[len(h.backlog)-scanLogBacklogSize:]
+	}
+	for ch := range h.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+func (h *scanLogHub) line(stream, line string) {
+	h.publish(scanLogEvent{Type: scanEventLog, Stream: stream, Line: line})
+}
+
+func (h *scanLogHub) phase(phase string) {
+	h.publish(scanLogEvent{Type: scanEventPhase, Phase: phase, Message: scanPhaseMessages[phase]})
+}
+
+func (h *scanLogHub) info(message string) {
+	h.publish(scanLogEvent{Type: scanEventInfo, Message: message})
+}
+
+// redactToken masks the SonarQube token in a line of analysis output, so
+// that it reaches neither the log subscribers nor the stored log.
+func redactToken(line, token string) string {
+	if token == "" {
+		return line
+	}
+	return strings.ReplaceAll(line, token, "[REDACTED]")
+}
+
+// close sends the end event, disconnects all subscribers and unregisters
+// the hub.
+func (h *scanLogHub) close(status string) {
+	if h == nil {
+		return
+	}
+	h.publish(scanLogEvent{Type: scanEventEnd, Status: status})
+
+	h.mu.Lock()
+	h.closed = true
+	for ch := range h.subscribers {
+		close(ch)
+	}
+	h.subscribers = nil
+	h.mu.Unlock()
+
+	scanLogHubs.Lock()
+	if scanLogHubs.hubs[h.scanID] == h {
+		delete(scanLogHubs.hubs, h.scanID)
+	}
+	scanLogHubs.Unlock()
+}
+
+// transcriptLines returns the recorded log so far.
+func (h *scanLogHub) transcriptLines() ([]string, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.transcript.lines(), h.transcript.truncated()
+}
+
+// subscribe returns the backlog and a channel of live events. The channel is
+// closed when the scan ends; ok is false if it already has.
+func (h *scanLogHub) subscribe() (backlog []scanLogEvent, events chan scanLogEvent, ok bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return nil, nil, false
+	}
+	events = make(chan scanLogEvent, scanLogSubscriberBuffer)
+	h.subscribers[events] = struct{}{}
+	return append([]scanLogEvent(nil), h.backlog...), events, true
+}
+
+func (h *scanLogHub) unsubscribe(events chan scanLogEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subscribers[events]; ok {
+		delete(h.subscribers, events)
+		close(events)
+	}
+}
+
+func streamScanLogsHandler(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	scanId := c.Param("scanId")
+	ctx := c.Request.Context()
+
+	var exists bool
+	err := dbPool.QueryRow(context.Background(), "SELECT EXISTS(SELECT 1 FROM scans WHERE id = $1 AND user_id = $2)", scanId, userID.(string)).Scan(&exists)
+	if err != nil || !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Scan not found"})
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	send := func(event scanLogEvent) {
+		c.SSEvent(event.Type, event)
+		c.Writer.Flush()
+	}
+	keepAlive := func() {
+		io.WriteString(c.Writer, ": keep-alive\n\n")
+		c.Writer.Flush()
+	}
+
+	// Until the scan shows up on this instance, report its status from the
+	// database. Scans running on another backend instance are followed this
+	// way to the end, without their container output.
+	var lastStatus, lastPhase string
+	for {
+		if hub := getScanLogHub(scanId); hub != nil {
+			if streamScanLogHub(ctx, hub, send, keepAlive) {
+				return
+			}
+		}
+
+		var status string
+		var phase *string
+		err := dbPool.QueryRow(context.Background(), `
+            SELECT s.status, j.phase
+            FROM scans s LEFT JOIN scan_jobs j ON j.scan_id = s.id
+            WHERE s.id = $1`, scanId).Scan(&status, &phase)
+		if err != nil {
+			send(scanLogEvent{Type: scanEventEnd, Message: "Scan status is unavailable.", Time: time.Now()})
+			return
+		}
+		if isTerminalScanStatus(status) {
+			send(scanLogEvent{Type: scanEventEnd, Status: status, Time: time.Now()})
+			return
+		}
+		if status != lastStatus {
+			lastStatus = status
+			send(scanLogEvent{Type: scanEventStatus, Status: status, Time: time.Now()})
+		}
+		if phase != nil && *phase != lastPhase {
+			lastPhase = *phase
+			send(scanLogEvent{Type: scanEventPhase, Phase: *phase, Message: scanPhaseMessages[*phase], Time: time.Now()})
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(2 * time.Second):
+			keepAlive()
+		}
+	}
+}
+
+// streamScanLogHub relays a hub's events until the scan ends or the client
+// disconnects, and reports whether the stream is finished. It returns false
+// if the hub closed before the client could subscribe.
+func streamScanLogHub(ctx context.Context, hub *scanLogHub, send func(scanLogEvent), keepAlive func()) bool {
+	backlog, events, ok := hub.subscribe()
+	if !ok {
+		return false
+	}
+	defer hub.unsubscribe(events)
+
+	for _, event := range backlog {
+		send(event)
+	}
+	ticker := time.NewTicker(15 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return true
+		case event, open := <-events:
+			if !open {
+				return true
+			}
+			send(event)
+		case <-ticker.C:
+			keepAlive()
+		}
+	}
+}
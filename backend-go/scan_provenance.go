@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// gitInfoFile is written by analyze.sh after checking out the repository:
+// the commit SHA, branch, author, commit date (ISO 8601) and message, one per
+// line, the message taking up the remaining lines.
+const gitInfoFile = "git-info.txt"
+
+// maxCommitMessageLength caps the commit message stored on a scan.
+const maxCommitMessageLength = 4000
+
+// ScanProvenance identifies the commit a scan analyzed. The fields are null
+// when the repository could not be cloned.
+type ScanProvenance struct {
+	CommitSHA     *string    `json:"commit_sha"`
+	Branch        *string    `json:"branch"`
+	CommitAuthor  *string    `json:"commit_author"`
+	CommitDate    *time.Time `json:"commit_date"`
+	CommitMessage *string    `json:"commit_message"`
+}
+
+// readGitInfo parses the git-info.txt analyze.sh left in outputDir. The file
+// is read whole, as a commit message may have lines of any length.
+func readGitInfo(outputDir string) (ScanProvenance, error) {
+	data, err := os.ReadFile(filepath.Join(outputDir, gitInfoFile))
+	if err != nil {
+		return ScanProvenance{}, err
+	}
+	// Postgres rejects invalid UTF-8, e.g. from a message in another
+	// encoding, which would lose the whole provenance.
+	lines := strings.Split(strings.ToValidUTF8(string(data), "\uFFFD"), "\n")
+	if len(lines) < 4 || strings.TrimSpace(lines[0]) == "" {
+		return ScanProvenance{}, errors.New("incomplete git info")
+	}
+
+	var p ScanProvenance
+	p.CommitSHA = nullIfEmpty(strings.TrimSpace(lines[0]))
+	p.Branch = nullIfEmpty(strings.TrimSpace(lines[1]))
+	p.CommitAuthor = nullIfEmpty(strings.TrimSpace(lines[2]))
+	if date, err := time.Parse(time.RFC3339, strings.TrimSpace(lines[3])); err == nil {
+		p.CommitDate = &date
+	}
+	message := strings.TrimSpace(strings.Join(lines[4:], "\n"))
+	p.CommitMessage = nullIfEmpty(truncateUTF8(message, maxCommitMessageLength))
+	return p, nil
+}
+
+// truncateUTF8 cuts s to at most n bytes without splitting a rune.
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// recordScanProvenance stores the commit a scan analyzed.
+func recordScanProvenance(ctx context.Context, scanID string, p ScanProvenance) error {
+	_, err := dbPool.Exec(ctx, `
+        UPDATE scans SET commit_sha = $1, branch = $2, commit_author = $3, commit_date = $4, commit_message = $5
+        WHERE id = $6`,
+		p.CommitSHA, p.Branch, p.CommitAuthor, p.CommitDate, p.CommitMessage, scanID)
+	if err != nil {
+		return fmt.Errorf("failed to record provenance of scan %s: %w", scanID, err)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestReadGitInfo(t *testing.T) {
+	longLine := strings.Repeat("x", 100*1024)
+	tests := []struct {
+		name        string
+		content     string
+		wantErr     bool
+		wantBranch  *string
+		wantMessage string
+	}{
+		{
+			name:        "branch and multi-line message",
+			content:     "0123abcd\nmain\nAda <ada@example.com>\n2024-05-01T10:00:00+02:00\nFix parser\n\nDetails.\n",
+			wantBranch:  nullIfEmpty("main"),
+			wantMessage: "Fix parser\n\nDetails.",
+		},
+		{
+			name:        "detached checkout",
+			content:     "0123abcd\n\nAda <ada@example.com>\n2024-05-01T10:00:00Z\nRelease\n",
+			wantMessage: "Release",
+		},
+		{
+			name:        "message line longer than a scanner buffer",
+			content:     "0123abcd\nmain\nAda\n2024-05-01T10:00:00Z\n" + longLine + "\n",
+			wantBranch:  nullIfEmpty("main"),
+			wantMessage: longLine[:maxCommitMessageLength],
+		},
+		{
+			name:        "invalid UTF-8 in the message",
+			content:     "0123abcd\nmain\nAda\n2024-05-01T10:00:00Z\nCaf\xe9\n",
+			wantBranch:  nullIfEmpty("main"),
+			wantMessage: "Caf�",
+		},
+		{
+			name:    "no commit",
+			content: "\n\n\n\n",
+			wantErr: true,
+		},
+		{
+			name:    "too few lines",
+			content: "0123abcd\nmain\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, gitInfoFile), []byte(tt.content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			p, err := readGitInfo(dir)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readGitInfo() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if p.CommitSHA == nil || *p.CommitSHA != "0123abcd" {
+				t.Errorf("CommitSHA = %v, want 0123abcd", p.CommitSHA)
+			}
+			if (p.Branch == nil) != (tt.wantBranch == nil) || (p.Branch != nil && *p.Branch != *tt.wantBranch) {
+				t.Errorf("Branch = %v, want %v", p.Branch, tt.wantBranch)
+			}
+			if p.CommitDate == nil {
+				t.Error("CommitDate not parsed")
+			}
+			if p.CommitMessage == nil || *p.CommitMessage != tt.wantMessage {
+				t.Errorf("CommitMessage = %.40q, want %.40q", derefOr(p.CommitMessage), tt.wantMessage)
+			}
+		})
+	}
+}
+
+func TestTruncateUTF8(t *testing.T) {
+	tests := []struct {
+		s    string
+		n    int
+		want string
+	}{
+		{"short", 10, "short"},
+		{"exact", 5, "exact"},
+		{"abcdef", 3, "abc"},
+		{"aé", 2, "a"},  // é is two bytes
+		{"a😀b", 4, "a"}, // the emoji is four bytes
+		{"😀", 0, ""},
+	}
+	for _, tt := range tests {
+		got := truncateUTF8(tt.s, tt.n)
+		if got != tt.want || !utf8.ValidString(got) {
+			t.Errorf("truncateUTF8(%q, %d) = %q, want %q", tt.s, tt.n, got, tt.want)
+		}
+	}
+}
+
+func derefOr(s *string) string {
+	if s == nil {
+		return "<nil>"
+	}
+	return *s
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultScanReportMaxBytes caps the size of an uploaded report. Override
+// with SCAN_REPORT_MAX_BYTES.
+const defaultScanReportMaxBytes = 20 << 20
+
+// sarifUploadName is the analyzer path parameter under which SARIF logs are
+// uploaded. Their findings are stored per tool named in the log.
+const sarifUploadName = "sarif"
+
+// uploadScanReportHandler attaches a report produced outside the analysis
+// container, e.g. by Android Lint in CI, to a finished scan. The report is
+// sent as the request body or as the "report" field of a multipart form, and
+// replaces an earlier upload for the same analyzer.
+func uploadScanReportHandler(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	scanId := c.Param("scanId")
+	ctx := context.Background()
+
+	var parse func(raw []byte) ([]analyzerOutput, error)
+	if name := c.Param("analyzer"); name == sarifUploadName {
+		parse = func(raw []byte) ([]analyzerOutput, error) {
+			return parseSarifReports([][]byte{raw}, "")
+		}
+	} else {
+		a, ok := findAnalyzer(name)
+		if uploader, isUploader := a.(reportUploader); !ok || !isUploader || !uploader.AcceptsUploads() {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Reports of this analyzer cannot be uploaded"})
+			return
+		}
+		parse = func(raw []byte) ([]analyzerOutput, error) {
+			out, err := parseAnalyzerReport(a, raw, "")
+			return []analyzerOutput{out}, err
+		}
+	}
+
+	var status string
+	err := dbPool.QueryRow(ctx, "SELECT status FROM scans WHERE id = $1 AND user_id = $2", scanId, userID.(string)).Scan(&status)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Scan not found"})
+		return
+	}
+	if status != scanStatusSucceeded && status != scanStatusPartial {
+		c.JSON(http.StatusConflict, gin.H{"error": "Reports can only be added to a scan that finished with results", "status": status})
+		return
+	}
+
+	raw, err := readUploadedReport(c)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Report is too large"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Could not read report: " + err.Error()})
+		return
+	}
+	if len(raw) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Report is empty"})
+		return
+	}
+
+	outputs, err := parse(raw)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Report could not be parsed: " + err.Error()})
+		return
+	}
+	stored := make([]gin.H, 0, len(outputs))
+	for _, out := range outputs {
+		if err := storeAnalyzerOutput(ctx, scanId, out); err != nil {
+			log.Printf("Failed to store uploaded %s report for scan %s: %v", out.Analyzer.Name(), scanId, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not save report", "results": stored})
+			return
+		}
+		stored = append(stored, gin.H{"analyzer": out.Analyzer.Name(), "version": out.Version, "summary": out.Summary})
+	}
+	c.JSON(http.StatusCreated, gin.H{"results": stored})
+}
+
+func readUploadedReport(c *gin.Context) ([]byte, error) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, getEnvBytes("SCAN_REPORT_MAX_BYTES", defaultScanReportMaxBytes))
+	if c.ContentType() != "multipart/form-data" {
+		return io.ReadAll(c.Request.Body)
+	}
+	file, _, err := c.Request.FormFile("report")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(file)
+}
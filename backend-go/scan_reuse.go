@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// fullCommitPattern matches a full SHA-1 or SHA-256 commit ID, which names
+// a commit unambiguously without looking at the repository.
+var fullCommitPattern = regexp.MustCompile(`^([0-9a-f]{40}|[0-9a-f]{64})$`)
+
+// resolvedCommit is the commit a scan would analyze, found without cloning.
+type resolvedCommit struct {
+	SHA    string
+	Branch string // empty when a tag or commit would be checked out
+}
+
+// scanConfigFingerprint hashes what decides a scan's results besides the
+// commit: the analysis image and the project's source and detekt settings.
+// The project's detekt baseline is left out as it is applied to stored
+// results rather than by the tools.
+func scanConfigFingerprint(image analyzerImage, sources projectSources, detekt projectDetektConfig) string {
+	data, _ := json.Marshal(struct {
+		Image       string
+		SourcePaths []string
+		Excludes    []string
+		Detekt      projectDetektConfig
+	}{image.Digest, append([]string{}, sources.SourcePaths...), append([]string{}, sources.Excludes...), detekt})
+	return sha256Hex(data)
+}
+
+// reuseUnchangedScan looks for a succeeded scan of the project that analyzed
+// the commit the job would analyze, with the same configuration. If there is
+// one its results are copied to the job's scan, which is linked to it, and
+// its ID is returned; otherwise the ID is empty and the analysis has to run.
+func reuseUnchangedScan(ctx context.Context, job scanJob, in analysisInputs, setPhase func(phase string), logs *scanLogHub) (string, error) {
+	commit, ok, err := resolveScanCommit(ctx, job, in)
+	if err != nil || !ok {
+		return "", err
+	}
+	image, err := scanRunner.resolveImage(ctx)
+	if err != nil {
+		return "", err
+	}
+	fingerprint := scanConfigFingerprint(image, in.Sources, in.Detekt)
+
+	var fromScanID string
+	err = dbPool.QueryRow(ctx, `
+        SELECT id FROM scans
+        WHERE project_id = $1 AND id <> $2 AND commit_sha = $3 AND config_fingerprint = $4
+          AND status = $5 AND reused_from_scan_id IS NULL
+        ORDER BY finished_at DESC LIMIT 1`,
+		job.ProjectID, job.ScanID, commit.SHA, fingerprint, scanStatusSucceeded).Scan(&fromScanID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to look for earlier scans of commit %s: %w", commit.SHA, err)
+	}
+
+	logs.info(fmt.Sprintf("Commit %s was already analyzed with the same configuration by scan %s; reusing its results. Request the scan with \"force\" to run the analysis again.", commit.SHA[:7], fromScanID))
+	setPhase(scanPhaseIngesting)
+	if err := copyScanResults(ctx, job.ScanID, fromScanID, commit); err != nil {
+		return "", err
+	}
+	return fromScanID, nil
+}
+
+// copyScanResults makes a scan a copy of an earlier one of the same commit:
+// its provenance, toolchain, metrics and results. The project's current
+// detekt baseline is then applied to the copied findings.
+func copyScanResults(ctx context.Context, scanID, fromScanID string, commit resolvedCommit) error {
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `
+            UPDATE scans s SET
+                reused_from_scan_id = f.id, branch = NULLIF($3, ''),
+                commit_sha = f.commit_sha, commit_author = f.commit_author, commit_date = f.commit_date, commit_message = f.commit_message,
+                detekt_config_source = f.detekt_config_source, detekt_config_path = f.detekt_config_path,
+                detekt_config_hash = f.detekt_config_hash, detekt_baseline_hash = f.detekt_baseline_hash,
+                analyzer_image = f.analyzer_image, analyzer_image_digest = f.analyzer_image_digest,
+                tool_versions = f.tool_versions, config_fingerprint = f.config_fingerprint,
+                lines_of_code = f.lines_of_code, maintainability_rating = f.maintainability_rating,
+                cognitive_complexity = f.cognitive_complexity
+            FROM scans f
+            WHERE s.id = $1 AND f.id = $2`,
+		scanID, fromScanID, commit.Branch)
+	if err != nil {
+		return fmt.Errorf("failed to link scan %s to scan %s: %w", scanID, fromScanID, err)
+	}
+	results := []struct{ what, sql string }{
+		{"detekt results", `
+            INSERT INTO detekt_results (scan_id, detekt_xml, error_issues, warning_issues, info_issues)
+            SELECT $1, detekt_xml, error_issues, warning_issues, info_issues
+            FROM detekt_results WHERE scan_id = $2`},
+		{"SonarQube results", `
+            INSERT INTO sonarqube_results (scan_id, sonar_json, blocker_issues, critical_issues, major_issues, minor_issues, info_issues, code_smells, bugs, vulnerabilities)
+            SELECT $1, sonar_json, blocker_issues, critical_issues, major_issues, minor_issues, info_issues, code_smells, bugs, vulnerabilities
+            FROM sonarqube_results WHERE scan_id = $2`},
+		{"analyzer results", `
+            INSERT INTO analyzer_results (scan_id, analyzer, analyzer_version, raw_report, summary, total_findings)
+            SELECT $1, analyzer, analyzer_version, raw_report, summary, total_findings
+            FROM analyzer_results WHERE scan_id = $2`},
+		{"findings", `
+            INSERT INTO scan_findings (scan_id, analyzer, rule_id, severity, category, message, file_path, line, fingerprint)
+            SELECT $1, analyzer, rule_id, severity, category, message, file_path, line, fingerprint
+            FROM scan_findings WHERE scan_id = $2 ORDER BY id`},
+	}
+	for _, r := range results {
+		if _, err := tx.Exec(ctx, r.sql, scanID, fromScanID); err != nil {
+			return fmt.Errorf("failed to copy %s of scan %s: %w", r.what, fromScanID, err)
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	if err := applyDetektBaseline(ctx, scanID); err != nil {
+		log.Printf("Failed to apply detekt baseline to scan %s: %v", scanID, err)
+	}
+	return nil
+}
+
+// resolveScanCommit finds the commit the analysis of a job would check out,
+// the way analyze.sh picks it: the pinned commit, else the branch or tag
+// named by the ref, else the ref as a commit SHA, else the default branch.
+// ok is false when that can't be told without cloning, e.g. for uploaded
+// sources or an abbreviated SHA.
+func resolveScanCommit(ctx context.Context, job scanJob, in analysisInputs) (resolvedCommit, bool, error) {
+	if in.SourceDir != "" {
+		return resolvedCommit{}, false, nil
+	}
+	if job.Commit != "" {
+		sha := strings.ToLower(job.Commit)
+		return resolvedCommit{SHA: sha, Branch: job.Ref}, fullCommitPattern.MatchString(sha), nil
+	}
+
+	if job.Ref == "" {
+		refs, err := lsRemote(ctx, job.RepoURL, in, "HEAD")
+		if err != nil {
+			return resolvedCommit{}, false, err
+		}
+		sha, ok := refs["HEAD"]
+		return resolvedCommit{SHA: sha, Branch: strings.TrimPrefix(refs["ref: HEAD"], "refs/heads/")}, ok, nil
+	}
+
+	branch, tag := "refs/heads/"+job.Ref, "refs/tags/"+job.Ref
+	refs, err := lsRemote(ctx, job.RepoURL, in, branch, tag, tag+"^{}")
+	if err != nil {
+		return resolvedCommit{}, false, err
+	}
+	switch {
+	case refs[branch] != "":
+		return resolvedCommit{SHA: refs[branch], Branch: job.Ref}, true, nil
+	case refs[tag+"^{}"] != "":
+		return resolvedCommit{SHA: refs[tag+"^{}"]}, true, nil
+	case refs[tag] != "":
+		return resolvedCommit{SHA: refs[tag]}, true, nil
+	}
+	sha := strings.ToLower(job.Ref)
+	return resolvedCommit{SHA: sha}, fullCommitPattern.MatchString(sha), nil
+}
+
+// lsRemote lists the refs of a job's repository that match patterns with
+// git ls-remote, using the project's clone credentials. Like the clone, it
+// connects to the address the repository URL was checked at. It returns the
+// object of every ref listed, and the target of a symbolic ref under
+// "ref: <name>".
+func lsRemote(ctx context.Context, repoURL string, in analysisInputs, patterns ...string) (map[string]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	remote := repoURL
+	config := map[string]string{"http.followRedirects": "false"}
+	env := []string{
+		"PATH=" + os.Getenv("PATH"),
+		"GIT_TERMINAL_PROMPT=0",
+		"GIT_CONFIG_NOSYSTEM=1",
+		"GIT_CONFIG_GLOBAL=" + os.DevNull,
+		"GIT_ALLOW_PROTOCOL=" + gitAllowProtocol(repoURL),
+	}
+	if in.LocalRepoDir != "" {
+		remote = in.LocalRepoDir
+		config["safe.directory"] = in.LocalRepoDir
+	}
+	if resolve := in.Repo.curlResolve(); resolve != "" {
+		config["http.curloptResolve"] = resolve
+	}
+	sshCommand := "ssh -o BatchMode=yes"
+	if in.Secrets != nil {
+		switch in.Secrets.Kind {
+		case credentialHTTPSToken:
+			username, err := os.ReadFile(filepath.Join(in.Secrets.Dir, "username"))
+			if err != nil {
+				return nil, err
+			}
+			token, err := os.ReadFile(filepath.Join(in.Secrets.Dir, "token"))
+			if err != nil {
+				return nil, err
+			}
+			config["http.extraHeader"] = "Authorization: Basic " + base64.StdEncoding.EncodeToString([]byte(string(username)+":"+string(token)))
+		case credentialSSHKey:
+			sshCommand += fmt.Sprintf(" -i %s -o IdentitiesOnly=yes -o StrictHostKeyChecking=accept-new -o UserKnownHostsFile=%s",
+				filepath.Join(in.Secrets.Dir, "ssh_key"), os.DevNull)
+		}
+	}
+	if options := in.Repo.sshOptions(); options != "" {
+		sshCommand += " " + options
+	}
+	env = append(env, "GIT_SSH_COMMAND="+sshCommand)
+	// Passed in the environment rather than with -c, so that the
+	// credentials don't show up in the process list.
+	env = append(env, fmt.Sprintf("GIT_CONFIG_COUNT=%d", len(config)))
+	i := 0
+	for key, value := range config {
+		env = append(env, fmt.Sprintf("GIT_CONFIG_KEY_%d=%s", i, key), fmt.Sprintf("GIT_CONFIG_VALUE_%d=%s", i, value))
+		i++
+	}
+
+	cmd := exec.CommandContext(ctx, "git", append([]string{"ls-remote", "--symref", "--", remote}, patterns...)...)
+	cmd.Env = env
+	out, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return nil, fmt.Errorf("git ls-remote failed: %s", in.Secrets.redactLine(strings.TrimSpace(string(exitErr.Stderr))))
+		}
+		return nil, fmt.Errorf("git ls-remote failed: %w", err)
+	}
+
+	refs := map[string]string{}
+	scanner := bufio.NewScanner(strings.NewReader(string(out)))
+	for scanner.Scan() {
+		object, name, ok := strings.Cut(scanner.Text(), "\t")
+		if !ok {
+			continue
+		}
+		if target, isSymref := strings.CutPrefix(object, "ref: "); isSymref {
+			refs["ref: "+name] = target
+		} else {
+			refs[name] = object
+		}
+	}
+	return refs, nil
+}
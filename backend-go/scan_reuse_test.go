@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestResolveScanCommitWithoutRemote(t *testing.T) {
+	sha := strings.Repeat("ab", 20)
+	tests := []struct {
+		name   string
+		job    scanJob
+		in     analysisInputs
+		want   resolvedCommit
+		wantOK bool
+	}{
+		{name: "uploaded sources", job: scanJob{Commit: sha}, in: analysisInputs{SourceDir: "/tmp/src"}},
+		{name: "pinned commit", job: scanJob{Ref: "main", Commit: strings.ToUpper(sha)}, want: resolvedCommit{SHA: sha, Branch: "main"}, wantOK: true},
+		{name: "abbreviated commit", job: scanJob{Commit: "abc1234"}, want: resolvedCommit{SHA: "abc1234"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok, err := resolveScanCommit(context.Background(), tt.job, tt.in)
+			if err != nil || ok != tt.wantOK || (ok && got != tt.want) {
+				t.Errorf("resolveScanCommit = %+v, %v, %v; want %+v, %v", got, ok, err, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+// gitRepo creates a repository with a commit on main, a lightweight tag and
+// an annotated tag, and returns its path with the commit SHA.
+func gitRepo(t *testing.T) (string, string) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir := t.TempDir()
+	git := func(args ...string) string {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+		cmd.Env = append(cmd.Environ(), "GIT_CONFIG_GLOBAL=/dev/null", "GIT_CONFIG_NOSYSTEM=1",
+			"GIT_AUTHOR_NAME=t", "GIT_AUTHOR_EMAIL=t@example.com", "GIT_COMMITTER_NAME=t", "GIT_COMMITTER_EMAIL=t@example.com")
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	git("init", "--quiet", "--initial-branch=main")
+	git("commit", "--quiet", "--allow-empty", "-m", "first")
+	git("tag", "light")
+	git("tag", "-a", "-m", "release", "v1")
+	return dir, git("rev-parse", "HEAD")
+}
+
+func TestResolveScanCommitWithLsRemote(t *testing.T) {
+	dir, sha := gitRepo(t)
+	in := analysisInputs{LocalRepoDir: dir}
+	tests := []struct {
+		name   string
+		ref    string
+		want   resolvedCommit
+		wantOK bool
+	}{
+		{name: "default branch", want: resolvedCommit{SHA: sha, Branch: "main"}, wantOK: true},
+		{name: "branch", ref: "main", want: resolvedCommit{SHA: sha, Branch: "main"}, wantOK: true},
+		{name: "lightweight tag", ref: "light", want: resolvedCommit{SHA: sha}, wantOK: true},
+		{name: "annotated tag", ref: "v1", want: resolvedCommit{SHA: sha}, wantOK: true},
+		{name: "full commit as ref", ref: sha, want: resolvedCommit{SHA: sha}, wantOK: true},
+		{name: "unknown ref", ref: "nope", want: resolvedCommit{SHA: "nope"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok, err := resolveScanCommit(context.Background(), scanJob{RepoURL: dir, Ref: tt.ref}, in)
+			if err != nil || ok != tt.wantOK || (ok && got != tt.want) {
+				t.Errorf("resolveScanCommit = %+v, %v, %v; want %+v, %v", got, ok, err, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+// The host of the URL doesn't resolve, so the request can only reach the
+// server through the pinned address.
+func TestLsRemoteConnectsToCheckedAddress(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	var mu sync.Mutex
+	var hosts []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hosts = append(hosts, r.Host)
+		mu.Unlock()
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+	serverURL, _ := url.Parse(server.URL)
+
+	t.Setenv("REPO_ALLOWED_SCHEMES", "http")
+	remote := "http://git.invalid:" + serverURL.Port() + "/owner/repo"
+	in := analysisInputs{Repo: checkedRepo{
+		URL:  repoURL{Scheme: "http", Host: "git.invalid", Port: serverURL.Port(), Path: "owner/repo"},
+		Addr: netip.MustParseAddr(serverURL.Hostname()),
+	}}
+	if _, err := lsRemote(context.Background(), remote, in, "HEAD"); err == nil {
+		t.Fatal("lsRemote succeeded against a server without repositories")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(hosts) == 0 || hosts[0] != "git.invalid:"+serverURL.Port() {
+		t.Errorf("server got requests for %q, want git.invalid", hosts)
+	}
+}
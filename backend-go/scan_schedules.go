@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// scanSchedule is a project's recurring scan. Expr is a cron expression or a
+// preset such as "@daily", evaluated in Timezone.
+type scanSchedule struct {
+	Expr          string     `json:"schedule"`
+	Timezone      string     `json:"timezone"`
+	Ref           *string    `json:"ref"`
+	Enabled       bool       `json:"enabled"`
+	NextRunAt     *time.Time `json:"nextRunAt"`
+	LastRunAt     *time.Time `json:"lastRunAt"`
+	LastScanID    *string    `json:"lastScanId"`
+	LastSkippedAt *time.Time `json:"lastSkippedAt"` // last run skipped because a scan was still in progress
+}
+
+// nextScheduledRun returns the first run of a schedule after t.
+func nextScheduledRun(expr, timezone string, t time.Time) (time.Time, error) {
+	cron, err := parseCron(expr)
+	if err != nil {
+		return time.Time{}, err
+	}
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("unknown timezone %q", timezone)
+	}
+	next := cron.next(t.In(loc))
+	if next.IsZero() {
+		return time.Time{}, errors.New("schedule never runs")
+	}
+	return next, nil
+}
+
+// startScanScheduler periodically queues the scans of due schedules. Due
+// times are stored, so runs missed while no backend was up happen once at
+// startup rather than being lost.
+func startScanScheduler(interval time.Duration) {
+	go func() {
+		for {
+			runDueScanSchedules(context.Background())
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// runDueScanSchedules queues a scan for every enabled schedule that is due
+// and moves it to its next run. A run is skipped when the project still has
+// a scan queued or running. The schedules are locked while this happens so
+// that several backends don't queue the same run.
+func runDueScanSchedules(ctx context.Context) {
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		log.Printf("Scan scheduler: %v", err)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `
+        SELECT sc.project_id, p.user_id, p.url, sc.cron_expr, sc.timezone, COALESCE(sc.ref, '')
+        FROM scan_schedules sc
+        INNER JOIN projects p ON p.id = sc.project_id
+        WHERE sc.enabled AND sc.next_run_at <= NOW()
+        FOR UPDATE OF sc SKIP LOCKED`)
+	if err != nil {
+		log.Printf("Scan scheduler: failed to load due schedules: %v", err)
+		return
+	}
+	type dueSchedule struct{ projectID, userID, url, expr, timezone, ref string }
+	var due []dueSchedule
+	for rows.Next() {
+		var d dueSchedule
+		if err := rows.Scan(&d.projectID, &d.userID, &d.url, &d.expr, &d.timezone, &d.ref); err != nil {
+			log.Printf("Scan scheduler: %v", err)
+			continue
+		}
+		due = append(due, d)
+	}
+	rows.Close()
+
+	now := time.Now()
+	for _, d := range due {
+		var scanID *string
+		var skipped bool
+		err := tx.QueryRow(ctx, "SELECT EXISTS(SELECT 1 FROM scans WHERE project_id = $1 AND status = ANY($2))",
+			d.projectID, []string{scanStatusQueued, scanStatusRunning}).Scan(&skipped)
+		if err != nil {
+			log.Printf("Scan scheduler: failed to check scans of project %s: %v", d.projectID, err)
+			continue
+		}
+		if skipped {
+			log.Printf("Scan scheduler: project %s still has a scan in progress, skipping this run", d.projectID)
+		} else if id, err := queueScan(ctx, d.projectID, d.userID, d.url, d.ref, "", false, nil); err != nil {
+			log.Printf("Scan scheduler: failed to queue scan of project %s: %v", d.projectID, err)
+		} else {
+			scanID = &id
+		}
+
+		// A schedule whose next run can't be computed any more is disabled
+		// rather than retried every tick.
+		next, err := nextScheduledRun(d.expr, d.timezone, now)
+		_, execErr := tx.Exec(ctx, `
+            UPDATE scan_schedules
+            SET next_run_at = CASE WHEN $2 THEN $3 ELSE next_run_at END, enabled = $2,
+                last_run_at = CASE WHEN $4::uuid IS NULL THEN last_run_at ELSE NOW() END,
+                last_scan_id = COALESCE($4, last_scan_id),
+                last_skipped_at = CASE WHEN $5 THEN NOW() ELSE last_skipped_at END
+            WHERE project_id = $1`,
+			d.projectID, err == nil, next, scanID, skipped)
+		if execErr != nil {
+			log.Printf("Scan scheduler: failed to update schedule of project %s: %v", d.projectID, execErr)
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		log.Printf("Scan scheduler: %v", err)
+	}
+}
+
+func loadScanSchedule(ctx context.Context, projectID string) (*scanSchedule, error) {
+	var s scanSchedule
+	err := dbPool.QueryRow(ctx, `
+        SELECT cron_expr, timezone, ref, enabled, next_run_at, last_run_at, last_scan_id, last_skipped_at
+        FROM scan_schedules WHERE project_id = $1`, projectID,
+	).Scan(&s.Expr, &s.Timezone, &s.Ref, &s.Enabled, &s.NextRunAt, &s.LastRunAt, &s.LastScanID, &s.LastSkippedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func getScanScheduleHandler(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	projectID := c.Param("id")
+	ctx := context.Background()
+
+	var exists bool
+	err := dbPool.QueryRow(ctx, "SELECT EXISTS(SELECT 1 FROM projects WHERE id=$1 AND user_id=$2)", projectID, userID.(string)).Scan(&exists)
+	if err != nil || !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+	schedule, err := loadScanSchedule(ctx, projectID)
+	if err != nil {
+		log.Printf("Failed to load schedule of project %s: %v", projectID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch schedule"})
+		return
+	}
+	if schedule == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "This project has no schedule"})
+		return
+	}
+	c.JSON(http.StatusOK, schedule)
+}
+
+// putScanScheduleHandler sets the recurring scan of a project: a cron
+// expression ("0 3 * * 1-5") or preset ("hourly", "daily", "weekly"), an
+// optional IANA timezone (UTC by default) and an optional ref to scan.
+func putScanScheduleHandler(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	projectID := c.Param("id")
+
+	var req struct {
+		Schedule string `json:"schedule" binding:"required"`
+		Timezone string `json:"timezone"`
+		Ref      string `json:"ref"`
+		Enabled  *bool  `json:"enabled"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: schedule is required."})
+		return
+	}
+	if req.Timezone == "" {
+		req.Timezone = "UTC"
+	}
+	req.Ref = strings.TrimSpace(req.Ref)
+	if req.Ref != "" && !validGitRef(req.Ref) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ref must be a branch, tag or commit SHA"})
+		return
+	}
+	next, err := nextScheduledRun(req.Schedule, req.Timezone, time.Now())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid schedule: " + err.Error()})
+		return
+	}
+	enabled := req.Enabled == nil || *req.Enabled
+
+	ctx := context.Background()
+	var url string
+	err = dbPool.QueryRow(ctx, "SELECT url FROM projects WHERE id = $1 AND user_id = $2", projectID, userID.(string)).Scan(&url)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+	if isUploadURL(url) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Projects of uploaded sources can't be scanned on a schedule"})
+		return
+	}
+
+	_, err = dbPool.Exec(ctx, `
+        INSERT INTO scan_schedules (project_id, cron_expr, timezone, ref, enabled, next_run_at)
+        VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6)
+        ON CONFLICT (project_id) DO UPDATE
+        SET cron_expr = EXCLUDED.cron_expr, timezone = EXCLUDED.timezone, ref = EXCLUDED.ref,
+            enabled = EXCLUDED.enabled, next_run_at = EXCLUDED.next_run_at, updated_at = NOW()`,
+		projectID, strings.TrimSpace(req.Schedule), req.Timezone, req.Ref, enabled, next)
+	if err != nil {
+		log.Printf("Failed to save schedule of project %s: %v", projectID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not save schedule"})
+		return
+	}
+	getScanScheduleHandler(c)
+}
+
+func deleteScanScheduleHandler(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	projectID := c.Param("id")
+
+	tag, err := dbPool.Exec(context.Background(), `
+        DELETE FROM scan_schedules sc
+        USING projects p
+        WHERE sc.project_id = p.id AND p.id = $1 AND p.user_id = $2`,
+		projectID, userID.(string))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not delete schedule"})
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "This project has no schedule"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Schedule removed"})
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Lifecycle states of a scan, stored in scans.status.
+const (
+	scanStatusQueued    = "queued"
+	scanStatusRunning   = "running"
+	scanStatusPartial   = "partial"   // finished, but some tools produced no results
+	scanStatusSucceeded = "succeeded" // finished with results from every tool
+	scanStatusFailed    = "failed"
+	scanStatusCancelled = "cancelled"
+)
+
+// scanStatusesWithResults are the final states in which a scan stored results
+// worth showing in analytics.
+var scanStatusesWithResults = []string{scanStatusSucceeded, scanStatusPartial}
+
+// scanStatusTransitions lists, for every target state, the states a scan may
+// move to it from. Terminal states are never a source, so a finished scan
+// can't be reopened; running -> queued is how a crashed worker's scan is
+// retried.
+var scanStatusTransitions = map[string][]string{
+	scanStatusQueued:    {scanStatusRunning},
+	scanStatusRunning:   {scanStatusQueued},
+	scanStatusPartial:   {scanStatusRunning},
+	scanStatusSucceeded: {scanStatusRunning},
+	scanStatusFailed:    {scanStatusQueued, scanStatusRunning},
+	scanStatusCancelled: {scanStatusQueued, scanStatusRunning},
+}
+
+// scanFailure records why a phase of a scan did not produce results.
+type scanFailure struct {
+	Phase  string `json:"phase"`
+	Reason string `json:"reason"`
+}
+
+// failureReason turns an error into the sentence shown as a failure reason,
+// e.g. "detekt did not produce a report" into "Detekt did not produce a
+// report."
+func failureReason(err error) string {
+	reason := err.Error()
+	if reason == "" {
+		return reason
+	}
+	r, size := utf8.DecodeRuneInString(reason)
+	reason = string(unicode.ToUpper(r)) + reason[size:]
+	if !strings.HasSuffix(reason, ".") {
+		reason += "."
+	}
+	return reason
+}
+
+func isTerminalScanStatus(status string) bool {
+	switch status {
+	case scanStatusPartial, scanStatusSucceeded, scanStatusFailed, scanStatusCancelled:
+		return true
+	default:
+		return false
+	}
+}
+
+// transitionScanStatus moves a scan to the given state if the state machine
+// allows it from the scan's current state. Terminal states also record
+// finished_at, the run duration and the failure reasons. It reports whether
+// the transition happened.
+func transitionScanStatus(ctx context.Context, scanID, to string, runStartedAt time.Time, failures []scanFailure) (bool, error) {
+	from, ok := scanStatusTransitions[to]
+	if !ok {
+		return false, fmt.Errorf("unknown scan status %q", to)
+	}
+	if failures == nil {
+		failures = []scanFailure{}
+	}
+	failuresJSON, err := json.Marshal(failures)
+	if err != nil {
+		return false, fmt.Errorf("failed to encode failure reasons: %w", err)
+	}
+
+	var finishedAt *time.Time
+	var durationMs *int64
+	if isTerminalScanStatus(to) {
+		now := time.Now()
+		finishedAt = &now
+		if !runStartedAt.IsZero() {
+			ms := now.Sub(runStartedAt).Milliseconds()
+			durationMs = &ms
+		}
+	}
+
+	tag, err := dbPool.Exec(ctx, `
+        UPDATE scans SET status = $1, finished_at = $2, duration_ms = $3, failure_reasons = $4
+        WHERE id = $5 AND status = ANY($6)`,
+		to, finishedAt, durationMs, failuresJSON, scanID, from)
+	if err != nil {
+		return false, fmt.Errorf("failed to move scan %s to %s: %w", scanID, to, err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// scanOutcome decides the final state of a scan that ran to completion.
+func scanOutcome(failures []scanFailure, storedAnyResults bool) string {
+	switch {
+	case len(failures) == 0:
+		return scanStatusSucceeded
+	case storedAnyResults:
+		return scanStatusPartial
+	default:
+		return scanStatusFailed
+	}
+}
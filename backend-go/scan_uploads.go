@@ -0,0 +1,399 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// uploadURLScheme prefixes the URL of projects whose sources are uploaded
+// rather than cloned, e.g. "upload://payments-service".
+const uploadURLScheme = "upload://"
+
+// sourceMountPath is where uploaded sources are mounted in the analysis
+// container.
+const sourceMountPath = "/source"
+
+// Kinds of accepted source archives.
+const (
+	archiveZip   = "zip"
+	archiveTarGz = "tar.gz"
+)
+
+// archiveLimits bounds an uploaded source archive. The extracted size and
+// entry count guard against archive bombs.
+type archiveLimits struct {
+	MaxBytes          int64
+	MaxEntries        int
+	MaxExtractedBytes int64
+}
+
+func loadArchiveLimits() archiveLimits {
+	return archiveLimits{
+		MaxBytes:          getEnvBytes("SCAN_UPLOAD_MAX_BYTES", 200<<20),
+		MaxEntries:        getEnvInt("SCAN_UPLOAD_MAX_ENTRIES", 50000),
+		MaxExtractedBytes: getEnvBytes("SCAN_UPLOAD_MAX_EXTRACTED_BYTES", 2<<30),
+	}
+}
+
+// scanUploadDir is where uploaded archives are kept until their scan has
+// finished. With more than one backend instance it must be shared by all of
+// them, as any instance's worker may pick up the scan.
+func scanUploadDir() string {
+	if dir := os.Getenv("SCAN_UPLOAD_DIR"); dir != "" {
+		return dir
+	}
+	return filepath.Join(os.TempDir(), "scan-uploads")
+}
+
+func isUploadURL(url string) bool {
+	return strings.HasPrefix(url, uploadURLScheme)
+}
+
+// archiveKind tells the format of an archive from its name, confirmed by the
+// magic bytes at the start of its data.
+func archiveKind(filename string, head []byte) (string, error) {
+	name := strings.ToLower(filename)
+	switch {
+	case strings.HasSuffix(name, ".zip") && bytes.HasPrefix(head, []byte("PK\x03\x04")):
+		return archiveZip, nil
+	case (strings.HasSuffix(name, ".tar.gz") || strings.HasSuffix(name, ".tgz")) && bytes.HasPrefix(head, []byte{0x1f, 0x8b}):
+		return archiveTarGz, nil
+	}
+	return "", errors.New("archive must be a .zip or .tar.gz file")
+}
+
+// archiveEntryPath resolves the path of an archive entry inside dest,
+// rejecting absolute paths and paths escaping dest ("zip slip").
+func archiveEntryPath(dest, name string) (string, error) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	if strings.HasPrefix(name, "/") || filepath.IsAbs(name) || filepath.VolumeName(name) != "" {
+		return "", fmt.Errorf("archive entry %q has an absolute path", name)
+	}
+	target := filepath.Join(dest, filepath.FromSlash(name))
+	rel, err := filepath.Rel(dest, target)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("archive entry %q escapes the extraction directory", name)
+	}
+	return target, nil
+}
+
+// archiveExtractor checks the entries of an archive against the limits and,
+// when write is set, writes them under root. Only directories and regular
+// files are extracted; links are skipped since they could point outside the
+// sources.
+type archiveExtractor struct {
+	root      string
+	write     bool
+	limits    archiveLimits
+	entries   int
+	extracted int64
+}
+
+func (x *archiveExtractor) entry(name string, isDir bool, content io.Reader) error {
+	x.entries++
+	if x.entries > x.limits.MaxEntries {
+		return fmt.Errorf("archive has more than %d entries", x.limits.MaxEntries)
+	}
+	target, err := archiveEntryPath(x.root, name)
+	if err != nil {
+		return err
+	}
+
+	if isDir {
+		if !x.write {
+			return nil
+		}
+		return os.MkdirAll(target, 0o755)
+	}
+
+	var out io.Writer = io.Discard
+	if x.write {
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	// The declared sizes of entries can't be trusted, so the limit is
+	// enforced on the bytes actually decompressed.
+	remaining := x.limits.MaxExtractedBytes - x.extracted
+	n, err := io.Copy(out, io.LimitReader(content, remaining+1))
+	x.extracted += n
+	if err != nil {
+		return fmt.Errorf("failed to extract %q: %w", name, err)
+	}
+	if n > remaining {
+		return fmt.Errorf("archive expands to more than %d bytes", x.limits.MaxExtractedBytes)
+	}
+	return nil
+}
+
+// extractArchive validates an archive of the given size and, when dest is
+// not empty, extracts it there.
+func extractArchive(archive io.ReaderAt, size int64, kind, dest string, limits archiveLimits) error {
+	x := &archiveExtractor{root: dest, write: dest != "", limits: limits}
+	if !x.write {
+		// Entry paths are still checked, against a placeholder root.
+		x.root = string(filepath.Separator) + "archive"
+	}
+	return walkArchive(archive, size, kind, x.entry)
+}
+
+func walkArchive(archive io.ReaderAt, size int64, kind string, visit func(name string, isDir bool, content io.Reader) error) error {
+	switch kind {
+	case archiveZip:
+		zr, err := zip.NewReader(archive, size)
+		if err != nil {
+			return fmt.Errorf("invalid zip archive: %w", err)
+		}
+		for _, f := range zr.File {
+			mode := f.Mode()
+			if !mode.IsDir() && !mode.IsRegular() {
+				continue
+			}
+			if err := visitZipEntry(f, visit); err != nil {
+				return err
+			}
+		}
+		return nil
+	case archiveTarGz:
+		gz, err := gzip.NewReader(io.NewSectionReader(archive, 0, size))
+		if err != nil {
+			return fmt.Errorf("invalid gzip stream: %w", err)
+		}
+		defer gz.Close()
+		tr := tar.NewReader(gz)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("invalid tar archive: %w", err)
+			}
+			switch hdr.Typeflag {
+			case tar.TypeDir:
+				err = visit(hdr.Name, true, nil)
+			case tar.TypeReg:
+				err = visit(hdr.Name, false, tr)
+			default:
+				continue
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return fmt.Errorf("unknown archive kind %q", kind)
+}
+
+func visitZipEntry(f *zip.File, visit func(name string, isDir bool, content io.Reader) error) error {
+	if f.Mode().IsDir() {
+		return visit(f.Name, true, nil)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return fmt.Errorf("failed to open %q: %w", f.Name, err)
+	}
+	defer rc.Close()
+	return visit(f.Name, false, rc)
+}
+
+// saveUploadedArchive streams an uploaded archive into the upload dir. It
+// returns the path of the copy and its size, or an *http.MaxBytesError if
+// the archive is larger than maxBytes.
+func saveUploadedArchive(upload io.Reader, maxBytes int64) (string, int64, error) {
+	dir := scanUploadDir()
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", 0, fmt.Errorf("failed to create upload dir: %w", err)
+	}
+	f, err := os.CreateTemp(dir, "upload-*")
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to store archive: %w", err)
+	}
+	defer f.Close()
+	size, err := io.Copy(f, io.LimitReader(upload, maxBytes+1))
+	if err == nil && size > maxBytes {
+		err = &http.MaxBytesError{Limit: maxBytes}
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", 0, err
+	}
+	return f.Name(), size, nil
+}
+
+// checkUploadedArchive tells the kind of a stored archive and validates it.
+func checkUploadedArchive(path, filename string, size int64, limits archiveLimits) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	head := make([]byte, 4)
+	n, _ := io.ReadFull(f, head)
+	kind, err := archiveKind(filename, head[:n])
+	if err != nil {
+		return "", err
+	}
+	if err := extractArchive(f, size, kind, "", limits); err != nil {
+		return "", err
+	}
+	return kind, nil
+}
+
+// prepareUploadedSource extracts the archive uploaded for a scan into dir.
+func prepareUploadedSource(ctx context.Context, scanID, dir string) error {
+	var kind, path string
+	err := dbPool.QueryRow(ctx, "SELECT archive_kind, archive_path FROM scan_uploads WHERE scan_id = $1", scanID).Scan(&kind, &path)
+	if err != nil {
+		return fmt.Errorf("failed to load uploaded sources of scan %s: %w", scanID, err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open uploaded sources of scan %s: %w", scanID, err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to open uploaded sources of scan %s: %w", scanID, err)
+	}
+	if err := extractArchive(f, info.Size(), kind, dir, loadArchiveLimits()); err != nil {
+		return fmt.Errorf("failed to extract uploaded sources: %w", err)
+	}
+	return nil
+}
+
+// deleteFinishedScanUploads drops the archives of scans that can no longer
+// run. Archives are kept until then so a retried scan can use them again.
+func deleteFinishedScanUploads(ctx context.Context) {
+	rows, err := dbPool.Query(ctx, `
+        DELETE FROM scan_uploads u
+        USING scans s
+        WHERE s.id = u.scan_id AND s.status <> ALL($1)
+        RETURNING u.archive_path`,
+		[]string{scanStatusQueued, scanStatusRunning})
+	if err != nil {
+		log.Printf("Failed to delete archives of finished scans: %v", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err == nil {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				log.Printf("Failed to delete archive %s: %v", path, err)
+			}
+		}
+	}
+}
+
+// uploadScanHandler scans the sources in an uploaded .zip or .tar.gz archive
+// (multipart field "archive") instead of cloning a repository. The optional
+// "projectName" field names the project; it defaults to the archive name.
+// Uploads with the same project name go to the same project.
+func uploadScanHandler(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	limits := loadArchiveLimits()
+
+	// Leave room for the other form fields on top of the archive.
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limits.MaxBytes+1<<20)
+	file, header, err := c.Request.FormFile("archive")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"success": false, "error": "Archive is too large"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Invalid request: an archive file is required."})
+		return
+	}
+	defer file.Close()
+	if header.Size > limits.MaxBytes {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"success": false, "error": "Archive is too large"})
+		return
+	}
+	path, size, err := saveUploadedArchive(file, limits.MaxBytes)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"success": false, "error": "Archive is too large"})
+			return
+		}
+		log.Printf("Failed to store uploaded archive: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Could not store archive"})
+		return
+	}
+	// The archive is removed with its scan once that has finished; until it
+	// is queued, it is removed here.
+	queued := false
+	defer func() {
+		if !queued {
+			os.Remove(path)
+		}
+	}()
+
+	kind, err := checkUploadedArchive(path, header.Filename, size, limits)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Invalid archive: " + err.Error()})
+		return
+	}
+
+	projectName := strings.TrimSpace(c.PostForm("projectName"))
+	if projectName == "" {
+		base := filepath.Base(header.Filename)
+		for _, ext := range []string{".zip", ".tar.gz", ".tgz"} {
+			if strings.HasSuffix(strings.ToLower(base), ext) {
+				base = base[:len(base)-len(ext)]
+				break
+			}
+		}
+		projectName = base
+	}
+	if projectName == "" || len(projectName) > 255 {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Invalid request: projectName must be 1 to 255 characters."})
+		return
+	}
+
+	ctx := context.Background()
+	projectURL := uploadURLScheme + projectName
+	projectID, err := findOrCreateProject(ctx, userID.(string), projectName, projectURL)
+	if err != nil {
+		log.Printf("Error saving project %s: %v", projectName, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Could not save project"})
+		return
+	}
+
+	scanID, err := queueScan(ctx, projectID, userID.(string), projectURL, "", "", false, func(scanID string) error {
+		_, err := dbPool.Exec(ctx, `
+            INSERT INTO scan_uploads (scan_id, filename, archive_kind, archive_path, size_bytes)
+            VALUES ($1, $2, $3, $4, $5)`,
+			scanID, filepath.Base(header.Filename), kind, path, size)
+		return err
+	})
+	if err != nil {
+		log.Printf("Failed to queue uploaded scan: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Could not queue scan"})
+		return
+	}
+	queued = true
+
+	c.JSON(http.StatusAccepted, gin.H{"scanId": scanID, "projectId": projectID, "status": scanStatusQueued})
+}
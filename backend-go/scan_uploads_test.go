@@ -0,0 +1,304 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type testArchiveEntry struct {
+	name    string
+	content string
+	dir     bool
+	symlink bool
+}
+
+func testZip(t *testing.T, entries []testArchiveEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &zip.FileHeader{Name: e.name, Method: zip.Deflate}
+		switch {
+		case e.dir:
+			hdr.SetMode(os.ModeDir | 0o755)
+		case e.symlink:
+			hdr.SetMode(os.ModeSymlink | 0o777)
+		default:
+			hdr.SetMode(0o644)
+		}
+		w, err := zw.CreateHeader(hdr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(e.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func testTarGz(t *testing.T, entries []testArchiveEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: 0o644, Size: int64(len(e.content)), Typeflag: tar.TypeReg}
+		switch {
+		case e.dir:
+			hdr.Typeflag, hdr.Mode, hdr.Size = tar.TypeDir, 0o755, 0
+		case e.symlink:
+			hdr.Typeflag, hdr.Linkname, hdr.Size = tar.TypeSymlink, e.content, 0
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			if _, err := tw.Write([]byte(e.content)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// readTree returns the regular files under root, by slash-separated path.
+func readTree(t *testing.T, root string) map[string]string {
+	t.Helper()
+	files := map[string]string{}
+	err := filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(root, path)
+		files[filepath.ToSlash(rel)] = string(data)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestArchiveEntryPath(t *testing.T) {
+	dest := filepath.FromSlash("/tmp/dest")
+	tests := []struct {
+		name    string
+		want    string
+		wantErr bool
+	}{
+		{name: "src/App.kt", want: "/tmp/dest/src/App.kt"},
+		{name: "./build.gradle", want: "/tmp/dest/build.gradle"},
+		{name: "src/../README.md", want: "/tmp/dest/README.md"},
+		{name: `src\main\App.kt`, want: "/tmp/dest/src/main/App.kt"},
+		{name: "..file", want: "/tmp/dest/..file"},
+		{name: "../evil", wantErr: true},
+		{name: "src/../../evil", wantErr: true},
+		{name: `..\evil`, wantErr: true},
+		{name: "/etc/passwd", wantErr: true},
+		{name: `\etc\passwd`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := archiveEntryPath(dest, tt.name)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("archiveEntryPath(%q) = %q, want an error", tt.name, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("archiveEntryPath(%q): %v", tt.name, err)
+			}
+			if want := filepath.FromSlash(tt.want); got != want {
+				t.Errorf("archiveEntryPath(%q) = %q, want %q", tt.name, got, want)
+			}
+		})
+	}
+}
+
+func TestArchiveKind(t *testing.T) {
+	entries := []testArchiveEntry{{name: "App.kt", content: "class App"}}
+	zipData := testZip(t, entries)
+	tarData := testTarGz(t, entries)
+	tests := []struct {
+		filename string
+		data     []byte
+		want     string
+		wantErr  bool
+	}{
+		{filename: "src.zip", data: zipData, want: archiveZip},
+		{filename: "SRC.ZIP", data: zipData, want: archiveZip},
+		{filename: "src.tar.gz", data: tarData, want: archiveTarGz},
+		{filename: "src.tgz", data: tarData, want: archiveTarGz},
+		{filename: "src.zip", data: tarData, wantErr: true},
+		{filename: "src.tar.gz", data: zipData, wantErr: true},
+		{filename: "src.rar", data: zipData, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := archiveKind(tt.filename, tt.data)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("archiveKind(%q) = %q, %v; want %q, error %v", tt.filename, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestExtractArchive(t *testing.T) {
+	limits := archiveLimits{MaxBytes: 1 << 20, MaxEntries: 10, MaxExtractedBytes: 100}
+	tests := []struct {
+		name    string
+		entries []testArchiveEntry
+		limits  archiveLimits
+		want    map[string]string
+		wantErr string
+	}{
+		{
+			name: "files and directories",
+			entries: []testArchiveEntry{
+				{name: "src/", dir: true},
+				{name: "src/App.kt", content: "class App"},
+				{name: "lib/util/Util.kt", content: "object Util"},
+			},
+			want: map[string]string{"src/App.kt": "class App", "lib/util/Util.kt": "object Util"},
+		},
+		{
+			name: "links skipped",
+			entries: []testArchiveEntry{
+				{name: "App.kt", content: "class App"},
+				{name: "passwd", content: "/etc/passwd", symlink: true},
+			},
+			want: map[string]string{"App.kt": "class App"},
+		},
+		{
+			name:    "zip slip",
+			entries: []testArchiveEntry{{name: "../evil.sh", content: "x"}},
+			wantErr: "escapes the extraction directory",
+		},
+		{
+			name:    "absolute path",
+			entries: []testArchiveEntry{{name: "/evil.sh", content: "x"}},
+			wantErr: "absolute path",
+		},
+		{
+			name:    "too many entries",
+			entries: []testArchiveEntry{{name: "a", content: "1"}, {name: "b", content: "2"}, {name: "c", content: "3"}},
+			limits:  archiveLimits{MaxBytes: 1 << 20, MaxEntries: 2, MaxExtractedBytes: 100},
+			wantErr: "more than 2 entries",
+		},
+		{
+			name:    "too large",
+			entries: []testArchiveEntry{{name: "a", content: strings.Repeat("a", 60)}, {name: "b", content: strings.Repeat("b", 60)}},
+			wantErr: "expands to more than 100 bytes",
+		},
+		{
+			name:    "exactly at the size limit",
+			entries: []testArchiveEntry{{name: "a", content: strings.Repeat("a", 100)}},
+			want:    map[string]string{"a": strings.Repeat("a", 100)},
+		},
+	}
+	kinds := []struct {
+		kind  string
+		build func(*testing.T, []testArchiveEntry) []byte
+	}{
+		{archiveZip, testZip},
+		{archiveTarGz, testTarGz},
+	}
+	for _, k := range kinds {
+		for _, tt := range tests {
+			t.Run(k.kind+"/"+tt.name, func(t *testing.T) {
+				l := tt.limits
+				if l == (archiveLimits{}) {
+					l = limits
+				}
+				data := k.build(t, tt.entries)
+
+				// Validation alone writes nothing but fails the same way.
+				validateErr := extractArchive(bytes.NewReader(data), int64(len(data)), k.kind, "", l)
+
+				dest := t.TempDir()
+				err := extractArchive(bytes.NewReader(data), int64(len(data)), k.kind, dest, l)
+				if tt.wantErr != "" {
+					if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+						t.Fatalf("extractArchive error = %v, want one containing %q", err, tt.wantErr)
+					}
+					if validateErr == nil || validateErr.Error() != err.Error() {
+						t.Errorf("validation error = %v, want %v", validateErr, err)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("extractArchive: %v", err)
+				}
+				if validateErr != nil {
+					t.Errorf("validation: %v", validateErr)
+				}
+				if got := readTree(t, dest); !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("extracted %v, want %v", got, tt.want)
+				}
+			})
+		}
+	}
+}
+
+func TestExtractArchiveInvalid(t *testing.T) {
+	limits := loadArchiveLimits()
+	for _, kind := range []string{archiveZip, archiveTarGz} {
+		if err := extractArchive(strings.NewReader("not an archive"), 14, kind, t.TempDir(), limits); err == nil {
+			t.Errorf("extractArchive(%s) of garbage succeeded, want an error", kind)
+		}
+	}
+	empty := testZip(t, nil)
+	if err := extractArchive(bytes.NewReader(empty), int64(len(empty)), "rar", "", limits); err == nil {
+		t.Error("extractArchive of an unknown kind succeeded, want an error")
+	}
+}
+
+func TestSaveUploadedArchive(t *testing.T) {
+	t.Setenv("SCAN_UPLOAD_DIR", t.TempDir())
+	data := testZip(t, []testArchiveEntry{{name: "src/App.kt", content: "class App"}})
+
+	path, size, err := saveUploadedArchive(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("saveUploadedArchive: %v", err)
+	}
+	if size != int64(len(data)) || filepath.Dir(path) != scanUploadDir() {
+		t.Errorf("saved %d bytes at %s, want %d in %s", size, path, len(data), scanUploadDir())
+	}
+	kind, err := checkUploadedArchive(path, "sources.zip", size, loadArchiveLimits())
+	if err != nil || kind != archiveZip {
+		t.Errorf("checkUploadedArchive = %q, %v; want %q", kind, err, archiveZip)
+	}
+	if _, err := checkUploadedArchive(path, "sources.tar.gz", size, loadArchiveLimits()); err == nil {
+		t.Error("checkUploadedArchive of a zip named .tar.gz succeeded, want an error")
+	}
+
+	var tooLarge *http.MaxBytesError
+	if _, _, err := saveUploadedArchive(bytes.NewReader(data), int64(len(data))-1); !errors.As(err, &tooLarge) {
+		t.Errorf("saveUploadedArchive over the limit: error = %v, want an *http.MaxBytesError", err)
+	}
+	if entries, _ := os.ReadDir(scanUploadDir()); len(entries) != 1 {
+		t.Errorf("upload dir has %d files, want only the first archive", len(entries))
+	}
+}
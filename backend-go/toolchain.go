@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// defaultAnalyzerImage is the analysis image used unless ANALYZER_IMAGE
+// names another, e.g. a pinned tag or digest.
+const defaultAnalyzerImage = "repo-analyzer:latest"
+
+// toolVersionsFile is written by analyze.sh: one "tool=version" line for
+// each tool it runs.
+const toolVersionsFile = "tool-versions.txt"
+
+// analyzerTools maps analyzers to the tool of tool-versions.txt they report
+// on, where the names differ.
+var analyzerTools = map[string]string{"sonarqube": "sonar-scanner"}
+
+// analyzerImage is what a scan's analysis ran in. Digest identifies the
+// image content, unlike a tag such as "latest": the registry digest when the
+// image has one, its local ID otherwise.
+type analyzerImage struct {
+	Ref    string
+	Digest string
+}
+
+func analyzerImageRef() string {
+	if image := os.Getenv("ANALYZER_IMAGE"); image != "" {
+		return image
+	}
+	return defaultAnalyzerImage
+}
+
+// readToolVersions reads the versions of the tools analyze.sh ran from
+// outputDir. Tools whose version couldn't be determined are left out.
+func readToolVersions(outputDir string) (map[string]string, error) {
+	f, err := os.Open(filepath.Join(outputDir, toolVersionsFile))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	versions := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		tool, version, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if ok && tool != "" && version != "" {
+			versions[tool] = version
+		}
+	}
+	return versions, scanner.Err()
+}
+
+// toolVersion returns the version analyze.sh reported for the tool behind
+// an analyzer, if any.
+func toolVersion(versions map[string]string, analyzer string) string {
+	if tool, ok := analyzerTools[analyzer]; ok {
+		return versions[tool]
+	}
+	return versions[analyzer]
+}
+
+// recordToolchain records what a scan ran with, and the fingerprint of its
+// configuration by which later scans of the same commit can reuse its
+// results.
+func recordToolchain(ctx context.Context, scanID string, image analyzerImage, versions map[string]string, fingerprint string) error {
+	if versions == nil {
+		versions = map[string]string{}
+	}
+	_, err := dbPool.Exec(ctx, `
+        UPDATE scans SET analyzer_image = NULLIF($1, ''), analyzer_image_digest = NULLIF($2, ''), tool_versions = $3,
+            config_fingerprint = $4
+        WHERE id = $5`,
+		image.Ref, image.Digest, versions, fingerprint, scanID)
+	if err != nil {
+		return fmt.Errorf("failed to record toolchain of scan %s: %w", scanID, err)
+	}
+	return nil
+}
+
+// scanToolchain is what a scan's results depend on besides the code: the
+// analysis image, the tool versions and the detekt configuration.
+type scanToolchain struct {
+	AnalyzerImage       *string           `json:"analyzer_image"`
+	AnalyzerImageDigest *string           `json:"analyzer_image_digest"`
+	ToolVersions        map[string]string `json:"tool_versions"`
+	DetektConfigHash    *string           `json:"detekt_config_hash"`
+	DetektBaselineHash  *string           `json:"detekt_baseline_hash"`
+}
+
+// toolchainChanges describes how the toolchain of a scan differs from the
+// one of the scan before it, e.g. "detekt 1.23.0 -> 1.23.6". Scans run
+// before toolchains were recorded don't count as a change.
+func toolchainChanges(prev, cur scanToolchain) []string {
+	if prev.ToolVersions == nil || cur.ToolVersions == nil {
+		return nil
+	}
+	var changes []string
+	for _, tool := range sortedKeys(cur.ToolVersions) {
+		if old, ok := prev.ToolVersions[tool]; ok && old != cur.ToolVersions[tool] {
+			changes = append(changes, fmt.Sprintf("%s %s -> %s", tool, old, cur.ToolVersions[tool]))
+		}
+	}
+	// A new image with the same tool versions may still bring other changes,
+	// e.g. to analyze.sh.
+	if len(changes) == 0 && !sameHash(prev.AnalyzerImageDigest, cur.AnalyzerImageDigest) {
+		changes = append(changes, "analyzer image")
+	}
+	if !sameHash(prev.DetektConfigHash, cur.DetektConfigHash) {
+		changes = append(changes, "detekt config")
+	}
+	if !sameHash(prev.DetektBaselineHash, cur.DetektBaselineHash) {
+		changes = append(changes, "detekt baseline")
+	}
+	return changes
+}
+
+func sameHash(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return *a == *b
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
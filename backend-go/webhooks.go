@@ -0,0 +1,416 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// maxWebhookPayloadBytes caps the size of a push payload; GitHub sends at
+// most 25 MB.
+const maxWebhookPayloadBytes = 25 << 20
+
+// defaultWebhookDeliveryRetention is how long handled deliveries are
+// remembered to refuse replays of them. Override with
+// WEBHOOK_DELIVERY_RETENTION.
+const defaultWebhookDeliveryRetention = 7 * 24 * time.Hour
+
+// pushEvent is what a push webhook reports, whichever forge sent it.
+type pushEvent struct {
+	Ref      string // e.g. refs/heads/main
+	After    string // commit the ref points to after the push
+	Deleted  bool
+	RepoURLs []string // clone and web URLs of the repository
+}
+
+// webhookPayload covers the fields of GitHub, Gitea and GitLab push payloads
+// that are needed to queue a scan.
+type webhookPayload struct {
+	Ref        string `json:"ref"`
+	After      string `json:"after"`
+	Deleted    bool   `json:"deleted"`
+	Repository struct {
+		CloneURL   string `json:"clone_url"`
+		SSHURL     string `json:"ssh_url"`
+		HTMLURL    string `json:"html_url"`
+		GitHTTPURL string `json:"git_http_url"` // GitLab
+		GitSSHURL  string `json:"git_ssh_url"`  // GitLab
+	} `json:"repository"`
+	Project struct { // GitLab
+		GitHTTPURL string `json:"git_http_url"`
+		GitSSHURL  string `json:"git_ssh_url"`
+		WebURL     string `json:"web_url"`
+	} `json:"project"`
+}
+
+// webhookRequest is a push webhook delivery with what it takes to verify it:
+// an HMAC-SHA256 signature of the body (GitHub, Gitea) or the secret token
+// itself (GitLab, which doesn't sign its payloads).
+type webhookRequest struct {
+	Event     string
+	Delivery  string // ID of the delivery, the same when it is redelivered
+	Signature string // hex-encoded HMAC-SHA256 of the body
+	Token     string
+	Body      []byte
+}
+
+// parseWebhookRequest recognises the forge that sent a webhook from its
+// headers. Gitea also sends GitHub's headers, so it is checked first.
+func parseWebhookRequest(h http.Header, body []byte) (webhookRequest, error) {
+	req := webhookRequest{Body: body}
+	switch {
+	case h.Get("X-Gitea-Event") != "":
+		req.Event = h.Get("X-Gitea-Event")
+		req.Delivery = h.Get("X-Gitea-Delivery")
+		req.Signature = h.Get("X-Gitea-Signature")
+	case h.Get("X-GitHub-Event") != "":
+		req.Event = h.Get("X-GitHub-Event")
+		req.Delivery = h.Get("X-GitHub-Delivery")
+		req.Signature = strings.TrimPrefix(h.Get("X-Hub-Signature-256"), "sha256=")
+	case h.Get("X-Gitlab-Event") != "":
+		req.Event = h.Get("X-Gitlab-Event")
+		req.Delivery = h.Get("X-Gitlab-Event-UUID")
+		req.Token = h.Get("X-Gitlab-Token")
+	default:
+		return req, errors.New("not a GitHub, GitLab or Gitea webhook")
+	}
+	return req, nil
+}
+
+// isPush tells push events apart from the rest; GitLab names them "Push
+// Hook".
+func (r webhookRequest) isPush() bool {
+	return r.Event == "push" || r.Event == "Push Hook"
+}
+
+// verify checks the delivery against a project's webhook secret.
+func (r webhookRequest) verify(secret string) bool {
+	if r.Token != "" {
+		return subtle.ConstantTimeCompare([]byte(r.Token), []byte(secret)) == 1
+	}
+	signature, err := hex.DecodeString(r.Signature)
+	if err != nil || len(signature) == 0 {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(r.Body)
+	return hmac.Equal(signature, mac.Sum(nil))
+}
+
+// recordWebhookDelivery remembers that a delivery was handled for a project.
+// It returns false if it was already, either under the same delivery ID or
+// with the same payload: the signature covers the body only, so a replay
+// may come with a delivery ID of its own.
+func recordWebhookDelivery(ctx context.Context, projectID string, r webhookRequest) (bool, error) {
+	payloadHash := sha256Hex(r.Body)
+	delivery := r.Delivery
+	if delivery == "" {
+		delivery = "sha256:" + payloadHash
+	}
+	tag, err := dbPool.Exec(ctx, `
+        INSERT INTO webhook_deliveries (project_id, delivery_id, payload_sha256)
+        VALUES ($1, $2, $3)
+        ON CONFLICT DO NOTHING`,
+		projectID, truncateUTF8(delivery, 255), payloadHash)
+	if err != nil {
+		return false, fmt.Errorf("failed to record webhook delivery: %w", err)
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// forgetWebhookDelivery drops the record of a delivery that couldn't be
+// handled, so that a redelivery is not refused.
+func forgetWebhookDelivery(ctx context.Context, projectID string, r webhookRequest) {
+	_, err := dbPool.Exec(ctx, "DELETE FROM webhook_deliveries WHERE project_id = $1 AND payload_sha256 = $2", projectID, sha256Hex(r.Body))
+	if err != nil {
+		log.Printf("Failed to forget webhook delivery for project %s: %v", projectID, err)
+	}
+}
+
+// pruneWebhookDeliveries forgets deliveries older than the retention window.
+func pruneWebhookDeliveries(ctx context.Context) {
+	retention := getEnvDuration("WEBHOOK_DELIVERY_RETENTION", defaultWebhookDeliveryRetention)
+	_, err := dbPool.Exec(ctx, "DELETE FROM webhook_deliveries WHERE received_at < $1", time.Now().Add(-retention))
+	if err != nil {
+		log.Printf("Failed to prune webhook deliveries: %v", err)
+	}
+}
+
+func parsePushEvent(body []byte) (pushEvent, error) {
+	var p webhookPayload
+	if err := json.Unmarshal(body, &p); err != nil {
+		return pushEvent{}, err
+	}
+	event := pushEvent{Ref: p.Ref, After: p.After, Deleted: p.Deleted || strings.Trim(p.After, "0") == ""}
+	for _, u := range []string{
+		p.Repository.CloneURL, p.Repository.SSHURL, p.Repository.HTMLURL, p.Repository.GitHTTPURL, p.Repository.GitSSHURL,
+		p.Project.GitHTTPURL, p.Project.GitSSHURL, p.Project.WebURL,
+	} {
+		if u != "" {
+			event.RepoURLs = append(event.RepoURLs, u)
+		}
+	}
+	return event, nil
+}
+
+// repoKey reduces a repository URL to host and path, so that the https,
+// SSH and web URLs of a repository compare equal:
+// "git@github.com:Owner/Repo.git" and "https://github.com/owner/repo" are
+// both "github.com/owner/repo". It returns "" for URLs that don't name a
+// remote repository.
+func repoKey(url string) string {
+	u := strings.TrimSpace(url)
+	if i := strings.Index(u, "://"); i >= 0 {
+		u = u[i+3:]
+		if at := strings.LastIndex(strings.SplitN(u, "/", 2)[0], "@"); at >= 0 {
+			u = u[at+1:]
+		}
+	} else if at, colon := strings.Index(u, "@"), strings.Index(u, ":"); at >= 0 && colon > at {
+		u = u[at+1:colon] + "/" + u[colon+1:]
+	} else {
+		return ""
+	}
+	host, path, _ := strings.Cut(u, "/")
+	if h, port, ok := strings.Cut(host, ":"); ok && (port == "22" || port == "443" || port == "80") {
+		host = h
+	}
+	path = strings.TrimSuffix(strings.Trim(path, "/"), ".git")
+	if host == "" || path == "" {
+		return ""
+	}
+	return strings.ToLower(host + "/" + path)
+}
+
+// pushWebhookHandler queues a scan of the pushed commit for every project
+// whose repository matches the push and whose webhook secret verifies it.
+// It isn't behind the session check: the secret is the authentication.
+// Deliveries already handled within the retention window are acknowledged
+// without queueing anything.
+func pushWebhookHandler(c *gin.Context) {
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxWebhookPayloadBytes))
+	if err != nil {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Payload too large"})
+		return
+	}
+	req, err := parseWebhookRequest(c.Request.Header, body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !req.isPush() {
+		// GitHub sends a ping when the webhook is created.
+		c.JSON(http.StatusOK, gin.H{"message": "Ignored " + req.Event + " event"})
+		return
+	}
+	push, err := parsePushEvent(body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid push payload"})
+		return
+	}
+
+	var keys []string
+	for _, u := range push.RepoURLs {
+		if key := repoKey(u); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	ctx := context.Background()
+	rows, err := dbPool.Query(ctx, `
+        SELECT p.id, p.user_id, p.url, w.secret_encrypted
+        FROM project_webhooks w
+        INNER JOIN projects p ON p.id = w.project_id
+        WHERE w.repo_key = ANY($1)`, keys)
+	if err != nil {
+		log.Printf("Failed to look up webhook projects: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not look up projects"})
+		return
+	}
+	type webhookProject struct{ id, userID, url string }
+	var matched []webhookProject
+	for rows.Next() {
+		var p webhookProject
+		var sealed []byte
+		if err := rows.Scan(&p.id, &p.userID, &p.url, &sealed); err != nil {
+			log.Printf("Error scanning webhook project row: %v", err)
+			continue
+		}
+		secret, err := decryptCredential(p.id, sealed)
+		if err != nil {
+			log.Printf("Failed to decrypt webhook secret of project %s: %v", p.id, err)
+			continue
+		}
+		if req.verify(secret) {
+			matched = append(matched, p)
+		}
+	}
+	rows.Close()
+	// A repository without webhooks gets the same answer as a bad
+	// signature, so that the endpoint doesn't tell which are set up.
+	if len(matched) == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid webhook signature"})
+		return
+	}
+
+	branch, isBranch := strings.CutPrefix(push.Ref, "refs/heads/")
+	if !isBranch || push.Deleted {
+		c.JSON(http.StatusOK, gin.H{"message": "Only pushes of new commits to branches are scanned"})
+		return
+	}
+	if !validGitRef(branch) || !validGitRef(push.After) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid branch or commit in push payload"})
+		return
+	}
+
+	pruneWebhookDeliveries(ctx)
+	scanIDs := make([]string, 0, len(matched))
+	replays := 0
+	for _, p := range matched {
+		fresh, err := recordWebhookDelivery(ctx, p.id, req)
+		if err != nil {
+			log.Print(err)
+			continue
+		}
+		if !fresh {
+			replays++
+			continue
+		}
+		scanID, err := queueScan(ctx, p.id, p.userID, p.url, branch, push.After, false, nil)
+		if err != nil {
+			log.Printf("Failed to queue scan of project %s for push to %s: %v", p.id, branch, err)
+			forgetWebhookDelivery(ctx, p.id, req)
+			continue
+		}
+		scanIDs = append(scanIDs, scanID)
+	}
+	if replays == len(matched) {
+		c.JSON(http.StatusOK, gin.H{"message": "Delivery already handled"})
+		return
+	}
+	if len(scanIDs) == 0 {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not queue scan"})
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"scanIds": scanIDs, "status": scanStatusQueued, "ref": branch, "commit": push.After})
+}
+
+func getProjectWebhookHandler(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	projectID := c.Param("id")
+
+	var exists bool
+	err := dbPool.QueryRow(context.Background(), "SELECT EXISTS(SELECT 1 FROM projects WHERE id=$1 AND user_id=$2)", projectID, userID.(string)).Scan(&exists)
+	if err != nil || !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+
+	var createdAt time.Time
+	err = dbPool.QueryRow(context.Background(),
+		"SELECT created_at FROM project_webhooks WHERE project_id = $1", projectID,
+	).Scan(&createdAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusOK, gin.H{"configured": false})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch webhook"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"configured": true, "url": "/api/webhooks/push", "createdAt": createdAt})
+}
+
+// putProjectWebhookHandler sets the secret push webhooks of a project are
+// verified with, replacing any earlier one. Without a "secret" in the body
+// one is generated. The secret is only returned by this call.
+func putProjectWebhookHandler(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	projectID := c.Param("id")
+
+	var req struct {
+		Secret string `json:"secret"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data."})
+			return
+		}
+	}
+	secret := strings.TrimSpace(req.Secret)
+	if secret == "" {
+		buf := make([]byte, 32)
+		if _, err := rand.Read(buf); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate secret"})
+			return
+		}
+		secret = hex.EncodeToString(buf)
+	} else if len(secret) < 16 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "secret must be at least 16 characters"})
+		return
+	}
+
+	ctx := context.Background()
+	var url string
+	err := dbPool.QueryRow(ctx, "SELECT url FROM projects WHERE id = $1 AND user_id = $2", projectID, userID.(string)).Scan(&url)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+	key := repoKey(url)
+	if key == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Webhooks are only supported for projects of remote repositories"})
+		return
+	}
+
+	sealed, err := encryptCredential(projectID, secret)
+	if err != nil {
+		log.Printf("Failed to encrypt webhook secret of project %s: %v", projectID, err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Credential storage is not configured on this server"})
+		return
+	}
+	_, err = dbPool.Exec(ctx, `
+        INSERT INTO project_webhooks (project_id, repo_key, secret_encrypted)
+        VALUES ($1, $2, $3)
+        ON CONFLICT (project_id) DO UPDATE
+        SET repo_key = EXCLUDED.repo_key, secret_encrypted = EXCLUDED.secret_encrypted, created_at = NOW()`,
+		projectID, key, sealed)
+	if err != nil {
+		log.Printf("Failed to store webhook of project %s: %v", projectID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not save webhook"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"configured": true, "url": "/api/webhooks/push", "secret": secret})
+}
+
+func deleteProjectWebhookHandler(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	projectID := c.Param("id")
+
+	tag, err := dbPool.Exec(context.Background(), `
+        DELETE FROM project_webhooks w
+        USING projects p
+        WHERE w.project_id = p.id AND p.id = $1 AND p.user_id = $2`,
+		projectID, userID.(string))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not delete webhook"})
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "This project has no webhook"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"configured": false})
+}
@@ -16,7 +16,16 @@ CREATE TABLE projects (
     name VARCHAR(255) NOT NULL,
     url TEXT NOT NULL,
     submitted_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
-    UNIQUE(user_id, url) -- A user can only have one project per unique URL
+    -- Per-project analysis container limits; NULL uses the deployment default
+    memory_limit_bytes BIGINT,
+    cpu_limit DOUBLE PRECISION,
+    pids_limit BIGINT,
+    max_runtime_seconds INTEGER,
+    max_disk_bytes BIGINT,
+    -- Parts of the repository to analyze, relative to its root; no source paths analyzes all of it
+    source_paths TEXT[] NOT NULL DEFAULT '{}',
+    exclude_globs TEXT[] NOT NULL DEFAULT '{}',
+    UNIQUE(user_id, url, source_paths) -- A user can have several projects per URL, each with its own source paths
 );
 
 -- SCANS TABLE: Records each analysis event for a project
@@ -25,6 +34,33 @@ CREATE TABLE scans (
     project_id UUID NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
     user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
     started_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    requested_ref VARCHAR(255), -- branch, tag or commit SHA asked for; NULL for the default branch
+    requested_commit VARCHAR(64), -- exact commit to analyze, e.g. the one reported by a push webhook
+    force_rerun BOOLEAN NOT NULL DEFAULT FALSE, -- analyze even if the commit was analyzed before
+    -- Earlier scan of the same commit and configuration whose results were copied instead of analyzing
+    reused_from_scan_id UUID REFERENCES scans(id) ON DELETE SET NULL,
+    -- Provenance of the analyzed commit, read from the clone
+    commit_sha VARCHAR(64),
+    branch VARCHAR(255), -- NULL when a tag or commit was checked out
+    commit_author TEXT,
+    commit_date TIMESTAMP WITH TIME ZONE,
+    commit_message TEXT,
+    -- detekt configuration the scan ran with, to tell comparable results apart
+    detekt_config_source VARCHAR(20), -- default, repository or custom
+    detekt_config_path TEXT, -- path of the repository's config file
+    detekt_config_hash VARCHAR(64), -- SHA-256 of the config; NULL for the default rule set
+    detekt_baseline_hash VARCHAR(64), -- SHA-256 of the baseline, if any
+    -- Toolchain the scan ran with, recorded by the worker
+    analyzer_image TEXT, -- image reference, or "local:<script>" for the local runner
+    analyzer_image_digest TEXT, -- registry digest or image ID the reference resolved to
+    tool_versions JSONB, -- e.g. {"detekt": "1.23.0", "ktlint": "1.3.1", "sonar-scanner": "5.0.1.3006"}
+    config_fingerprint VARCHAR(64), -- SHA-256 of the image digest and the source and detekt settings
+    -- Lifecycle: queued -> running -> partial | succeeded | failed, or cancelled
+    status VARCHAR(20) NOT NULL DEFAULT 'queued',
+    finished_at TIMESTAMP WITH TIME ZONE,
+    duration_ms BIGINT, -- time from a worker picking the scan up to it finishing
+    -- Why a phase produced no results, e.g. [{"phase": "sonar-processing", "reason": "..."}]
+    failure_reasons JSONB NOT NULL DEFAULT '[]',
     -- SonarQube summary metrics that will be updated after analysis
     lines_of_code INTEGER,
     maintainability_rating INTEGER, -- e.g., A=1, B=2, C=3, D=4, E=5
@@ -40,7 +76,10 @@ CREATE TABLE detekt_results (
     -- Aggregated issue counts for quick lookups
     error_issues INTEGER,
     warning_issues INTEGER,
-    info_issues INTEGER
+    info_issues INTEGER,
+    -- Split of the issues against the project's baseline; NULL when it had none
+    new_issues INTEGER,
+    baseline_suppressed_issues INTEGER
 );
 
 -- SONARQUBE RESULTS TABLE: Stores the raw JSON output from a SonarQube scan
@@ -61,9 +100,146 @@ CREATE TABLE sonarqube_results (
     vulnerabilities INTEGER
 );
 
+-- SCAN JOBS TABLE: Durable queue of scans waiting for (or being processed by) a worker
+CREATE TABLE scan_jobs (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    scan_id UUID NOT NULL UNIQUE REFERENCES scans(id) ON DELETE CASCADE,
+    repo_url TEXT NOT NULL,
+    sonar_project_key TEXT NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'queued', -- queued, running, completed, failed, cancelled
+    phase VARCHAR(32), -- progress of a running job, e.g. cloning, detekt, ingesting
+    cancel_requested BOOLEAN NOT NULL DEFAULT FALSE, -- set by POST /api/scan/:scanId/cancel
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT,
+    -- Worker that claimed the job; heartbeat_at is refreshed while it runs so
+    -- jobs abandoned by a crashed backend can be requeued
+    worker_id TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    started_at TIMESTAMP WITH TIME ZONE,
+    heartbeat_at TIMESTAMP WITH TIME ZONE,
+    finished_at TIMESTAMP WITH TIME ZONE
+);
+
+-- SCAN LOGS TABLE: Stores the gzip-compressed output of a scan's analysis container
+CREATE TABLE scan_logs (
+    scan_id UUID PRIMARY KEY REFERENCES scans(id) ON DELETE CASCADE,
+    log_gzip BYTEA NOT NULL,
+    size_bytes INTEGER NOT NULL, -- uncompressed size, capped by SCAN_LOG_MAX_BYTES
+    line_count INTEGER NOT NULL,
+    truncated BOOLEAN NOT NULL DEFAULT FALSE, -- lines were dropped from the middle to stay under the cap
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- PROJECT CREDENTIALS TABLE: HTTPS token or SSH deploy key used to clone a private repository
+CREATE TABLE project_credentials (
+    project_id UUID PRIMARY KEY REFERENCES projects(id) ON DELETE CASCADE,
+    kind VARCHAR(20) NOT NULL, -- https_token or ssh_key
+    username VARCHAR(255), -- sent with an HTTPS token
+    secret_encrypted BYTEA NOT NULL, -- AES-256-GCM nonce + ciphertext, keyed by CREDENTIALS_ENCRYPTION_KEY
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- PROJECT WEBHOOKS TABLE: Secret that push webhooks of a project's repository are verified with
+CREATE TABLE project_webhooks (
+    project_id UUID PRIMARY KEY REFERENCES projects(id) ON DELETE CASCADE,
+    repo_key TEXT NOT NULL, -- host and path of the project's URL, e.g. github.com/owner/repo, matched against pushes
+    secret_encrypted BYTEA NOT NULL, -- AES-256-GCM nonce + ciphertext, keyed by CREDENTIALS_ENCRYPTION_KEY
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- WEBHOOK DELIVERIES TABLE: Push deliveries handled per project, kept for WEBHOOK_DELIVERY_RETENTION to refuse replays
+CREATE TABLE webhook_deliveries (
+    project_id UUID NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+    delivery_id VARCHAR(255) NOT NULL, -- X-GitHub-Delivery, X-Gitea-Delivery or X-Gitlab-Event-UUID; the payload hash without one
+    payload_sha256 CHAR(64) NOT NULL,
+    received_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (project_id, delivery_id),
+    UNIQUE (project_id, payload_sha256)
+);
+
+-- PROJECT DETEKT CONFIG TABLE: How detekt is configured for a project; projects without a row use the repository's config file
+CREATE TABLE project_detekt_config (
+    project_id UUID PRIMARY KEY REFERENCES projects(id) ON DELETE CASCADE,
+    mode VARCHAR(20) NOT NULL, -- default, repository or custom
+    config_path TEXT, -- repository mode: path of the config file in the repository; NULL looks in the usual places
+    config_yaml TEXT, -- custom mode: the detekt config
+    baseline_xml TEXT, -- detekt baseline passed to every scan
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- DETEKT BASELINES TABLE: Detekt issues a project has accepted, taken from one of its scans
+CREATE TABLE detekt_baselines (
+    project_id UUID PRIMARY KEY REFERENCES projects(id) ON DELETE CASCADE,
+    source_scan_id UUID REFERENCES scans(id) ON DELETE SET NULL,
+    fingerprints TEXT[] NOT NULL, -- one per issue, repeated for issues occurring more than once
+    issues JSONB NOT NULL DEFAULT '[]', -- the frozen issues, in the common finding model
+    issue_count INTEGER NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- SCAN UPLOADS TABLE: Source archive uploaded for a scan, kept until the scan has finished
+CREATE TABLE scan_uploads (
+    scan_id UUID PRIMARY KEY REFERENCES scans(id) ON DELETE CASCADE,
+    filename VARCHAR(255) NOT NULL,
+    archive_kind VARCHAR(10) NOT NULL, -- zip or tar.gz
+    archive_path TEXT NOT NULL, -- file under SCAN_UPLOAD_DIR
+    size_bytes BIGINT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- SCAN SCHEDULES TABLE: Recurring scan of a project, queued by the backend's scheduler
+CREATE TABLE scan_schedules (
+    project_id UUID PRIMARY KEY REFERENCES projects(id) ON DELETE CASCADE,
+    cron_expr TEXT NOT NULL, -- five-field cron expression or a preset such as "daily"
+    timezone VARCHAR(64) NOT NULL DEFAULT 'UTC', -- IANA zone the expression is evaluated in
+    ref VARCHAR(255), -- branch, tag or commit to scan; NULL for the default branch
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    next_run_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    last_run_at TIMESTAMP WITH TIME ZONE,
+    last_scan_id UUID REFERENCES scans(id) ON DELETE SET NULL,
+    last_skipped_at TIMESTAMP WITH TIME ZONE, -- last run skipped because a scan was still in progress
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- ANALYZER RESULTS TABLE: One row per analyzer that produced a report for a scan
+CREATE TABLE analyzer_results (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    scan_id UUID NOT NULL REFERENCES scans(id) ON DELETE CASCADE,
+    analyzer VARCHAR(50) NOT NULL,
+    analyzer_version VARCHAR(50) NOT NULL,
+    raw_report TEXT, -- NULL for analyzers that keep their report in a table of their own (detekt, sonarqube)
+    summary JSONB NOT NULL DEFAULT '{}', -- total and counts by severity and category
+    total_findings INTEGER NOT NULL DEFAULT 0,
+    detected_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (scan_id, analyzer)
+);
+
+-- SCAN FINDINGS TABLE: Findings of every analyzer in a common model
+CREATE TABLE scan_findings (
+    id BIGSERIAL PRIMARY KEY,
+    scan_id UUID NOT NULL REFERENCES scans(id) ON DELETE CASCADE,
+    analyzer VARCHAR(50) NOT NULL,
+    rule_id TEXT NOT NULL,
+    severity VARCHAR(20) NOT NULL, -- blocker, critical, major, minor or info
+    category TEXT,
+    message TEXT,
+    file_path TEXT,
+    line INTEGER,
+    fingerprint VARCHAR(40), -- rule, file and message hashed; stable across scans as lines move
+    baseline_suppressed BOOLEAN NOT NULL DEFAULT FALSE -- in the project's detekt baseline
+);
+
 -- INDEXES: Add indexes to foreign keys and frequently queried columns to improve performance
 CREATE INDEX idx_projects_user_id ON projects(user_id);
 CREATE INDEX idx_scans_project_id ON scans(project_id);
 CREATE INDEX idx_scans_user_id ON scans(user_id);
 CREATE INDEX idx_detekt_results_scan_id ON detekt_results(scan_id);
 CREATE INDEX idx_sonarqube_results_scan_id ON sonarqube_results(scan_id);
+CREATE INDEX idx_scan_jobs_status_created_at ON scan_jobs(status, created_at);
+CREATE INDEX idx_scan_findings_scan_id_analyzer ON scan_findings(scan_id, analyzer);
+CREATE INDEX idx_project_webhooks_repo_key ON project_webhooks(repo_key);
+CREATE INDEX idx_webhook_deliveries_received_at ON webhook_deliveries(received_at);
+CREATE INDEX idx_scan_schedules_next_run_at ON scan_schedules(next_run_at) WHERE enabled;
+CREATE INDEX idx_scans_project_id_commit_sha ON scans(project_id, commit_sha);
@@ -15,6 +15,7 @@ import {
   PieChart,
   Pie,
   Cell,
+  ReferenceLine,
 } from "recharts";
 import { useAnalyticsQuery } from "../hooks/useAnalyticsQuery";
 import "./AnalyticsTab.css";
@@ -36,6 +37,9 @@ const CustomTooltip = ({ active, payload, label }: any) => {
             style={{ color: pld.color }}
           >{`${pld.name}: ${pld.value}`}</p>
         ))}
+        {payload[0].payload.toolchainChanges?.length > 0 && (
+          <p className="toolchain-change">{`Toolchain changed: ${payload[0].payload.toolchainChanges.join(", ")}`}</p>
+        )}
       </div>
     );
   }
@@ -49,6 +53,10 @@ const NoDataMessage = ({ message }: { message: string }) => (
 const SONAR_TYPE_COLORS = ["#d9534f", "#f0ad4e", "#5cb85c"];
 const DETEKT_SEVERITY_COLORS = ["#d9534f", "#f0ad4e", "#5bc0de"];
 
+// Analyzers with dedicated charts; findings of any other analyzer, e.g. one
+// ingested from SARIF, are shown generically.
+const BUILT_IN_ANALYZERS = ["detekt", "sonarqube", "ktlint", "android-lint"];
+
 const formatRating = (tickItem: number) => {
   const ratings = ["", "A", "B", "C", "D", "E"];
   return ratings[tickItem] || "";
@@ -75,22 +83,43 @@ const AnalyticsTab: React.FC<AnalyticsTabProps> = ({ projectId }) => {
     latest_detekt_distribution,
     latest_sonar_rules,
     latest_detekt_rules,
+    latest_ktlint_rules,
+    latest_android_lint_rules,
     latest_noisy_files,
+    latest_rules_by_analyzer,
   } = data;
 
+  const otherAnalyzers = Object.keys(latest_rules_by_analyzer)
+    .filter((name) => !BUILT_IN_ANALYZERS.includes(name))
+    .sort();
+
   // --- Prepare Data for Charts ---
   const formattedTrendData = trend_data.map((d, i) => ({
-    name: `Scan ${i + 1}`,
+    name: d.commit_sha
+      ? `Scan ${i + 1} (${d.commit_sha.slice(0, 7)})`
+      : `Scan ${i + 1}`,
     "SonarQube Issues": d.total_sonar_issues,
     "Detekt Issues": d.total_detekt_issues,
+    "New Detekt Issues": d.detekt_new_issues ?? null,
+    "ktlint Issues": d.total_ktlint_issues,
+    "Android Lint Issues": d.total_android_lint_issues,
     "Maintainability Rating": d.maintainability_rating,
     "Cognitive Complexity": d.cognitive_complexity,
     "Lines of Code": d.lines_of_code,
     Blocker: d.blocker_issues,
     Critical: d.critical_issues,
     Major: d.major_issues,
+    toolchainChanges: d.toolchain_changes,
   }));
 
+  // Points where the analyzer image, tool versions or detekt config changed;
+  // a jump there may come from the toolchain rather than the code.
+  const toolchainChangePoints = formattedTrendData.filter(
+    (_, i) => trend_data[i].toolchain_changed,
+  );
+
+  const hasDetektBaseline = trend_data.some((d) => d.detekt_new_issues != null);
+
   const sonarIssueTypeData = [
     { name: "Bugs", value: latest_scan_data.bugs },
     { name: "Vulnerabilities", value: latest_scan_data.vulnerabilities },
@@ -116,6 +145,15 @@ const AnalyticsTab: React.FC<AnalyticsTabProps> = ({ projectId }) => {
                 <YAxis allowDecimals={false} />
                 <Tooltip content={<CustomTooltip />} />
                 <Legend />
+                {toolchainChangePoints.map((point) => (
+                  <ReferenceLine
+                    key={point.name}
+                    x={point.name}
+                    stroke="#999"
+                    strokeDasharray="3 3"
+                    label={{ value: "Toolchain", position: "top", fontSize: 11 }}
+                  />
+                ))}
                 <Line
                   type="monotone"
                   dataKey="SonarQube Issues"
@@ -128,6 +166,28 @@ const AnalyticsTab: React.FC<AnalyticsTabProps> = ({ projectId }) => {
                   stroke="#8884d8"
                   strokeWidth={2}
                 />
+                {hasDetektBaseline && (
+                  <Line
+                    type="monotone"
+                    dataKey="New Detekt Issues"
+                    stroke="#8884d8"
+                    strokeDasharray="5 5"
+                    strokeWidth={2}
+                    connectNulls
+                  />
+                )}
+                <Line
+                  type="monotone"
+                  dataKey="ktlint Issues"
+                  stroke="#ffc658"
+                  strokeWidth={2}
+                />
+                <Line
+                  type="monotone"
+                  dataKey="Android Lint Issues"
+                  stroke="#3ddc84"
+                  strokeWidth={2}
+                />
               </LineChart>
             </ResponsiveContainer>
           ) : (
@@ -302,6 +362,58 @@ const AnalyticsTab: React.FC<AnalyticsTabProps> = ({ projectId }) => {
           )}
         </div>
 
+        <div className="analytics-card">
+          <h3 className="analytics-title">Top 5 Violated ktlint Rules</h3>
+          {latest_ktlint_rules.length > 0 ? (
+            <ResponsiveContainer width="100%" height={300}>
+              <BarChart
+                data={latest_ktlint_rules}
+                layout="vertical"
+                margin={{ left: 150 }}
+              >
+                <XAxis type="number" />
+                <YAxis
+                  dataKey="rule_name"
+                  type="category"
+                  width={150}
+                  tick={{ fontSize: 12 }}
+                  interval={0}
+                />
+                <Tooltip content={<CustomTooltip />} />
+                <Bar dataKey="issue_count" name="Violations" fill="#ffc658" />
+              </BarChart>
+            </ResponsiveContainer>
+          ) : (
+            <NoDataMessage message="No ktlint rules data for this scan." />
+          )}
+        </div>
+
+        <div className="analytics-card">
+          <h3 className="analytics-title">Top 5 Violated Android Lint Rules</h3>
+          {latest_android_lint_rules.length > 0 ? (
+            <ResponsiveContainer width="100%" height={300}>
+              <BarChart
+                data={latest_android_lint_rules}
+                layout="vertical"
+                margin={{ left: 150 }}
+              >
+                <XAxis type="number" />
+                <YAxis
+                  dataKey="rule_name"
+                  type="category"
+                  width={150}
+                  tick={{ fontSize: 12 }}
+                  interval={0}
+                />
+                <Tooltip content={<CustomTooltip />} />
+                <Bar dataKey="issue_count" name="Violations" fill="#3ddc84" />
+              </BarChart>
+            </ResponsiveContainer>
+          ) : (
+            <NoDataMessage message="No Android Lint rules data for this scan." />
+          )}
+        </div>
+
         <div className="analytics-card">
           <h3 className="analytics-title">Latest Scan Issue Types (Sonar)</h3>
           {sonarIssueTypeData.length > 0 ? (
@@ -359,6 +471,30 @@ const AnalyticsTab: React.FC<AnalyticsTabProps> = ({ projectId }) => {
             <NoDataMessage message="No file data for this scan." />
           )}
         </div>
+
+        {otherAnalyzers.map((name) => (
+          <div className="analytics-card" key={name}>
+            <h3 className="analytics-title">Top 5 Violated {name} Rules</h3>
+            <ResponsiveContainer width="100%" height={300}>
+              <BarChart
+                data={latest_rules_by_analyzer[name]}
+                layout="vertical"
+                margin={{ left: 150 }}
+              >
+                <XAxis type="number" />
+                <YAxis
+                  dataKey="rule_name"
+                  type="category"
+                  width={150}
+                  tick={{ fontSize: 12 }}
+                  interval={0}
+                />
+                <Tooltip content={<CustomTooltip />} />
+                <Bar dataKey="issue_count" name="Violations" fill="#a78bfa" />
+              </BarChart>
+            </ResponsiveContainer>
+          </div>
+        ))}
       </div>
     </div>
   );
@@ -6,14 +6,24 @@ import { z } from 'zod';
 const TrendDataSchema = z.object({
   scan_id: z.string(),
   detected_at: z.string().transform((date) => new Date(date)),
+  commit_sha: z.string().nullable().optional(),
+  branch: z.string().nullable().optional(),
+  tool_versions: z.record(z.string()).nullable().optional(),
+  toolchain_changed: z.boolean().optional().default(false),
+  toolchain_changes: z.array(z.string()).nullable().optional().transform(val => val ?? []),
   maintainability_rating: z.number().nullable(),
   cognitive_complexity: z.number().nullable(),
   lines_of_code: z.number().nullable(),
   total_detekt_issues: z.number(),
+  detekt_new_issues: z.number().nullable().optional(),
+  detekt_baseline_issues: z.number().nullable().optional(),
   total_sonar_issues: z.number(),
+  total_ktlint_issues: z.number(),
+  total_android_lint_issues: z.number(),
   blocker_issues: z.number(),
   critical_issues: z.number(),
   major_issues: z.number(),
+  issues_by_analyzer: z.record(z.number()).nullable().transform(val => val ?? {}),
 });
 
 const RuleBreakdownSchema = z.object({
@@ -44,7 +54,10 @@ const AnalyticsResponseSchema = z.object({
   latest_detekt_distribution: LatestDetektDistributionSchema,
   latest_sonar_rules: z.array(RuleBreakdownSchema).nullable().transform(val => val ?? []),
   latest_detekt_rules: z.array(RuleBreakdownSchema).nullable().transform(val => val ?? []),
+  latest_ktlint_rules: z.array(RuleBreakdownSchema).nullable().transform(val => val ?? []),
+  latest_android_lint_rules: z.array(RuleBreakdownSchema).nullable().transform(val => val ?? []),
   latest_noisy_files: z.array(FileBreakdownSchema).nullable().transform(val => val ?? []),
+  latest_rules_by_analyzer: z.record(z.array(RuleBreakdownSchema)).nullable().transform(val => val ?? {}),
 });
 
 export type AnalyticsData = z.infer<typeof AnalyticsResponseSchema>;
@@ -2,6 +2,10 @@ import { useMutation } from '@tanstack/react-query';
 
 interface ScanRequest {
   repoUrl: string;
+  // Optional branch, tag or commit SHA; the default branch when omitted.
+  ref?: string;
+  // Re-run the analysis even if the commit was already analyzed.
+  force?: boolean;
 }
 
 interface ScanResponse {
@@ -11,14 +15,14 @@ interface ScanResponse {
 
 export const useScanMutation = () => {
   return useMutation<ScanResponse, Error, ScanRequest>({
-    mutationFn: async ({ repoUrl }) => {
+    mutationFn: async ({ repoUrl, ref, force }) => {
       const response = await fetch('http://localhost:4000/api/scan', {
         method: 'POST',
         headers: {
           'Content-Type': 'application/json',
         },
         credentials: 'include',
-        body: JSON.stringify({ repoUrl }),
+        body: JSON.stringify({ repoUrl, ref: ref?.trim() || undefined, force: force || undefined }),
       });
 
       if (!response.ok) {
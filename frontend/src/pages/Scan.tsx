@@ -24,21 +24,36 @@ const GitIcon = () => (
 );
 const LoadingSpinner = () => <div className="spinner"></div>;
 
+const STATUS_POLL_INTERVAL_MS = 3000;
+
+interface ScanStatusResponse {
+  status: "queued" | "running" | "partial" | "succeeded" | "failed" | "cancelled";
+  phase?: string;
+  error?: string;
+  failureReasons?: { phase: string; reason: string }[];
+  reusedFromScanId?: string | null;
+}
+
 const Scan: React.FC = () => {
   const location = useLocation();
   const [repoUrl, setRepoUrl] = useState(location.state?.repoUrl || "");
+  const [ref, setRef] = useState("");
+  const [force, setForce] = useState(false);
+  const [reusedFromScanId, setReusedFromScanId] = useState<string | null>(null);
   const [detektXML, setDetektXML] = useState<string | null>(null);
   const [sonarQubeData, setSonarQubeData] = useState<any>(null);
 
   // Manually control a single loading state for the entire process.
   const [isScanning, setIsScanning] = useState(false);
+  const [scanPhase, setScanPhase] = useState<string | null>(null);
+  const [scanError, setScanError] = useState<string | null>(null);
 
   const { mutateAsync, error: mutationError } = useScanMutation();
 
   const initialScanStartedRef = useRef(false);
 
   const startScan = useCallback(
-    async (urlToScan: string) => {
+    async (urlToScan: string, refToScan?: string, forceRerun?: boolean) => {
       // Prevent starting a new scan if one is already in progress.
       if (isScanning || !urlToScan.trim()) return;
 
@@ -46,9 +61,44 @@ const Scan: React.FC = () => {
       setIsScanning(true);
       setDetektXML(null);
       setSonarQubeData(null);
+      setScanPhase(null);
+      setScanError(null);
+      setReusedFromScanId(null);
 
       try {
-        const { scanId } = await mutateAsync({ repoUrl: urlToScan });
+        const { scanId } = await mutateAsync({
+          repoUrl: urlToScan,
+          ref: refToScan,
+          force: forceRerun,
+        });
+
+        // The scan runs in the background; poll its status until it is done.
+        for (;;) {
+          await new Promise((resolve) =>
+            setTimeout(resolve, STATUS_POLL_INTERVAL_MS)
+          );
+          const statusRes = await fetch(
+            `http://localhost:4000/api/scan/${scanId}/status`,
+            { credentials: "include" }
+          );
+          if (!statusRes.ok) {
+            throw new Error(`Failed to fetch scan status: ${statusRes.statusText}`);
+          }
+          const status: ScanStatusResponse = await statusRes.json();
+          setScanPhase(status.phase ?? status.status);
+          if (status.status === "failed" || status.status === "cancelled") {
+            setScanError(
+              status.failureReasons?.map((f) => `${f.phase}: ${f.reason}`).join("; ") ||
+                status.error ||
+                `Scan ${status.status}`
+            );
+            return;
+          }
+          if (status.status === "succeeded" || status.status === "partial") {
+            setReusedFromScanId(status.reusedFromScanId ?? null);
+            break;
+          }
+        }
 
         const detektRes = await fetch(
           `http://localhost:4000/api/scan/${scanId}/detekt`,
@@ -95,10 +145,10 @@ const Scan: React.FC = () => {
 
   const handleFormSubmit = (e: React.FormEvent) => {
     e.preventDefault();
-    startScan(repoUrl);
+    startScan(repoUrl, ref, force);
   };
 
-  const error = mutationError;
+  const error = mutationError ?? (scanError ? new Error(scanError) : null);
 
   return (
     <div className="page-container">
@@ -124,6 +174,25 @@ const Scan: React.FC = () => {
                 disabled={isScanning}
               />
             </div>
+            <div className="input-group">
+              <input
+                type="text"
+                className="auth-inputField"
+                placeholder="Branch, tag or commit (optional)"
+                value={ref}
+                onChange={(e) => setRef(e.target.value)}
+                disabled={isScanning}
+              />
+            </div>
+            <label className="scan-force-option">
+              <input
+                type="checkbox"
+                checked={force}
+                onChange={(e) => setForce(e.target.checked)}
+                disabled={isScanning}
+              />
+              Re-run even if this commit was already analyzed
+            </label>
             <button
               type="submit"
               className="auth-button"
@@ -138,6 +207,7 @@ const Scan: React.FC = () => {
           <div className="status-container-scan">
             <LoadingSpinner />
             <p>Scan in progress... This may take several minutes.</p>
+            {scanPhase && <p>Current step: {scanPhase}</p>}
           </div>
         )}
 
@@ -152,6 +222,12 @@ const Scan: React.FC = () => {
         {(detektXML || sonarQubeData) && !isScanning && (
           <div className="results-wrapper">
             <h2>Scan Results</h2>
+            {reusedFromScanId && (
+              <p className="scan-reused-note">
+                This commit was already analyzed with the same configuration;
+                the results of that scan are shown.
+              </p>
+            )}
             {detektXML && (
               <div className="results-card">
                 <h3>Detekt Analysis</h3>